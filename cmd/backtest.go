@@ -46,6 +46,14 @@ var backtestCmd = &cobra.Command{
 		config.Verbose = verbose
 		config.Resolver = resolver
 
+		err := loadPropertySources()
+		if err != nil {
+			log.Error("Unable to load properties: %v", err)
+			return err
+		}
+
+		handleShutdown()
+
 		/*
 		 *  Find all of the directories and/or files specified on the command line.
 		 *  If nothing is specified, add the current directory
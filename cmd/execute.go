@@ -9,9 +9,11 @@ import (
 	"raygun/config"
 	"raygun/finder"
 	"raygun/log"
+	"raygun/opa"
 	"raygun/parser"
 	"raygun/report"
 	"raygun/runner"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -85,6 +87,24 @@ var executeCmd = &cobra.Command{
 		config.Verbose = verbose
 		config.Resolver = resolver
 
+		err := loadPropertySources()
+		if err != nil {
+			log.Error("Unable to load properties: %v", err)
+			return err
+		}
+
+		if !opa.ValidExplainMode(config.Explain) {
+			err = fmt.Errorf("unsupported --explain %s, expecting one of %s", config.Explain, strings.Join(opa.EXPLAIN_MODES, ", "))
+			log.Error("%v", err)
+			return err
+		}
+
+		if config.CoverageLcov != "" {
+			config.Coverage = true
+		}
+
+		handleShutdown()
+
 		/*
 		 *  Find all of the directories and/or files specified on the command line.
 		 *  If nothing is specified, add the current directory
@@ -162,7 +182,15 @@ var executeCmd = &cobra.Command{
 
 		output := reporter.Generate(results)
 
-		log.Normal(output)
+		log.Normal("%s", output)
+
+		if config.CoverageLcov != "" {
+			err = report.WriteLcov(results, config.CoverageLcov)
+			if err != nil {
+				log.Error("%v", err)
+				return err
+			}
+		}
 
 		/*
 		 * Fail with an error code, so build tools can detect it
@@ -0,0 +1,83 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+	"raygun/config"
+	"raygun/generator"
+	"raygun/log"
+
+	"github.com/spf13/cobra"
+)
+
+/*
+   Generate creates a skeleton .raygun suite, so testers don't have to scaffold
+   hundreds of tests by hand.
+
+   For now, the only source is an OpenAPI document: every path and method becomes
+   a test (or an allowed/denied pair, when the operation declares a security
+   requirement), shaped as an Envoy CheckRequest or a generic HTTP request.
+*/
+
+var openApiFile string
+var generateDecisionPath string
+var generateBundlePath string
+var generateInputStyle string
+var generateOutput string
+var generateSuiteName string
+
+var generateCmd = &cobra.Command{
+	Use:   "generate --openapi <spec> --decision-path <path>",
+	Short: "Generate a skeleton .raygun suite from an OpenAPI document",
+	Long:  `Generate a skeleton .raygun suite with a test for every path and method in an OpenAPI document`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		config.Debug = debug
+		config.Verbose = verbose
+
+		if openApiFile == "" || generateDecisionPath == "" {
+			return errors.New("both --openapi and --decision-path are required")
+		}
+
+		openApiGenerator := generator.NewOpenApiGenerator(generateDecisionPath)
+		openApiGenerator.BundlePath = generateBundlePath
+		openApiGenerator.InputStyle = generateInputStyle
+		openApiGenerator.SuiteName = generateSuiteName
+
+		suite, err := openApiGenerator.Generate(openApiFile)
+
+		if err != nil {
+			log.Error("Unable to generate a suite from %s: %v", openApiFile, err)
+			return err
+		}
+
+		if generateOutput == "" {
+			os.Stdout.WriteString(suite)
+			return nil
+		}
+
+		err = os.WriteFile(generateOutput, []byte(suite), 0644)
+		if err != nil {
+			log.Error("Unable to write %s: %v", generateOutput, err)
+			return err
+		}
+
+		log.Normal("Generated suite written to %s", generateOutput)
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(generateCmd)
+
+	generateCmd.Flags().StringVar(&openApiFile, "openapi", "", "The OpenAPI (v3 or Swagger v2) document, YAML or JSON")
+	generateCmd.Flags().StringVar(&generateDecisionPath, "decision-path", "", "The decision path every generated test will query")
+	generateCmd.Flags().StringVar(&generateBundlePath, "bundle-path", "", "The bundle path for the opa: section of the generated suite")
+	generateCmd.Flags().StringVar(&generateInputStyle, "input-style", "envoy", "The shape of the generated inputs (envoy, http)")
+	generateCmd.Flags().StringVarP(&generateOutput, "output", "o", "", "Write the suite to this file instead of stdout")
+	generateCmd.Flags().StringVar(&generateSuiteName, "suite", "", "The suite name (defaults to the title of the API)")
+}
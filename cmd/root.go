@@ -5,7 +5,11 @@ package cmd
 
 import (
 	"os"
+	"os/signal"
 	"raygun/config"
+	"raygun/log"
+	"raygun/opa"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -23,6 +27,13 @@ var Version = "v0.3.2"
 
 var resolver = config.NewPropertyResolver()
 
+// property files and profiles, loaded once the command line has been parsed
+var propertyFiles []string
+var profile string
+var profilesFile = DEFAULT_PROFILES_FILE
+
+const DEFAULT_PROFILES_FILE = "raygun-profiles.yaml"
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "raygun",
@@ -71,6 +82,15 @@ func init() {
 		"OPA executable. Consider env var: RAYGUN_OPA_EXEC")
 	rootCmd.PersistentFlags().StringVar(&config.OpaLogPath, "opa-log", config.OpaLogPath, "Location of the OPA log file")
 	rootCmd.PersistentFlags().Uint16Var(&config.OpaPort, "opa-port", config.OpaPort, "The port upon which OPA is listening")
+	rootCmd.PersistentFlags().DurationVar(&config.OpaShutdownTimeout, "opa-shutdown-timeout", config.OpaShutdownTimeout, "How long to wait for OPA to exit after SIGTERM, before killing it")
+
+	// load bundles from a service, the way OPA does in production
+	rootCmd.PersistentFlags().BoolVar(&config.BundleServer, "bundle-server", config.BundleServer, "Serve the bundle to OPA over HTTP (with polling and ETags) instead of loading it with -b")
+	rootCmd.PersistentFlags().DurationVar(&config.BundlePollingInterval, "bundle-polling-interval", config.BundlePollingInterval, "How often OPA polls for a new bundle, when loading bundles from a server")
+	rootCmd.PersistentFlags().DurationVar(&config.OpaStartupTimeout, "opa-startup-timeout", config.OpaStartupTimeout, "How long to wait for OPA to become healthy and activate its bundles")
+
+	// evaluate in-process instead of starting OPA
+	rootCmd.PersistentFlags().StringVar(&config.OpaEngine, "engine", config.OpaEngine, "How decisions are evaluated: http (start an OPA process), embedded (in-process, no OPA executable needed) or wasm (a Wasm-compiled policy)")
 
 	// specify a remote server where the bundle can be found
 	rootCmd.PersistentFlags().StringVarP(&config.OpaBundleUrl, "opa-bundle-url", "b", config.OpaBundleUrl, "URL of a hosted OPA bundle")
@@ -87,12 +107,81 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&config.ReportFormat, "report-format",
 		config.ReportFormat, "Format of the test completion report (text, json)")
 
+	// flags related to understanding failures
+	rootCmd.PersistentFlags().StringVar(&config.Explain, "explain", config.Explain, "When a test fails, ask OPA to explain the decision and add the trace to the report: off, notes, fails or full")
+
 	// flags related to performance
 	rootCmd.PersistentFlags().BoolVar(&config.PerformanceMetrics, "perf-metrics", false, "Measure the time required for each call & report")
+	rootCmd.PersistentFlags().BoolVar(&config.OpaMetrics, "opa-metrics", false, "Ask OPA for its evaluation timers with each request, and report them per suite and decision path")
+	rootCmd.PersistentFlags().BoolVar(&config.Coverage, "coverage", false, "Collect line coverage of the policies the suites' inputs exercise, and report it per file and rule")
+	rootCmd.PersistentFlags().StringVar(&config.CoverageLcov, "coverage-lcov", "", "Also write the coverage to this file, in lcov format (implies --coverage)")
+	rootCmd.PersistentFlags().IntVar(&config.Concurrency, "concurrency", config.Concurrency, "The number of tests within a suite sent to OPA at the same time")
+	rootCmd.PersistentFlags().IntVar(&config.ParallelSuites, "parallel-suites", config.ParallelSuites, "Run suites with different OPA configurations in parallel, each on its own OPA and port")
+
+	// flags related to property substitution
+	rootCmd.PersistentFlags().StringArrayVar(&propertyFiles, "properties", nil, "A .properties, .env or YAML file of properties (repeatable)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "The named profile (from the profiles file) that selects a set of property files")
+	rootCmd.PersistentFlags().StringVar(&profilesFile, "profiles-file", profilesFile, "The YAML file that maps profile names to property files")
 
 	// Note: -D flags are handled by PropertyResolver before cobra sees them
 	rootCmd.SetHelpTemplate(rootCmd.HelpTemplate() +
-		"\nDynamic Properties:\n  -D KEY=VALUE    Define property (repeatable, takes precedence over env vars)\n")
+		"\nDynamic Properties:\n  -D KEY=VALUE    Define property (repeatable, takes precedence over property files, profiles and env vars)\n")
 
 	rootCmd.SetArgs(filteredArgs)
 }
+
+/*
+ *  The -D properties are parsed before cobra runs, but the property files and
+ *  profiles are named by regular flags, so they can only be loaded once cobra
+ *  has parsed the command line
+ */
+func loadPropertySources() error {
+
+	err := resolver.LoadProfile(profilesFile, profile)
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range propertyFiles {
+		err = resolver.LoadPropertyFile(filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  Make sure we never leave an OPA we started running behind us - whether we're
+ *  interrupted, or bail out through log.Fatal. Otherwise the next run would find
+ *  a stale OPA, with the wrong bundle, listening on the port
+ */
+func handleShutdown() {
+
+	log.RegisterExitHook(opa.StopAll)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go stopOnSignal(signals, opa.StopAll, os.Exit)
+}
+
+func stopOnSignal(signals <-chan os.Signal, stop func(), exit func(int)) {
+	sig := <-signals
+	log.Warning("Received %v, stopping OPA", sig)
+	stop()
+	exit(signalExitCode(sig))
+}
+
+/*
+ *  The shell's convention: 128 plus the signal number
+ */
+func signalExitCode(sig os.Signal) int {
+
+	if sig == syscall.SIGTERM {
+		return 143
+	}
+
+	return 130
+}
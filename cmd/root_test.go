@@ -0,0 +1,30 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package cmd
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestStopOnSignal(t *testing.T) {
+
+	tests := map[os.Signal]int{os.Interrupt: 130, syscall.SIGTERM: 143}
+
+	for sig, expected := range tests {
+
+		signals := make(chan os.Signal, 1)
+		signals <- sig
+
+		stopped := false
+		code := -1
+
+		stopOnSignal(signals, func() { stopped = true }, func(c int) { code = c })
+
+		if !stopped || code != expected {
+			t.Errorf("%v: expected OPA to be stopped and exit %d, got stopped=%v exit %d", sig, expected, stopped, code)
+		}
+	}
+}
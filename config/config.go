@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 const STANDARD_OPA_PORT uint16 = 8181
@@ -15,6 +16,9 @@ const DEFAULT_RAYGUN_EXTENSION = ".raygun"
 const DEFAULT_LOG_FILE = "raygun_opa.log"
 const DEFAULT_BUNDLE_URL = ""
 const DEFAULT_DECISION_ARRAY_FILE = "backtest.json"
+const DEFAULT_OPA_STARTUP_TIMEOUT = 30 * time.Second
+const DEFAULT_OPA_SHUTDOWN_TIMEOUT = 5 * time.Second
+const DEFAULT_BUNDLE_POLLING_INTERVAL = 1 * time.Second
 
 // const DEFAULT_RAYSUITE_EXTENSION = ".raysuite"
 
@@ -38,6 +42,21 @@ var OpaLogPath = filepath.FromSlash(fmt.Sprintf("%s/%s", os.Getenv("TMP"), DEFAU
 var OpaBundleUrl = "file:///bundle.tar.gz"
 var OpaEndpointUrl = ""
 
+// http (start an OPA process), embedded (evaluate in-process) or wasm (evaluate a Wasm-compiled policy)
+var OpaEngine = "http"
+
+// how long we wait for OPA to report that it's healthy, and its bundles activated
+var OpaStartupTimeout = DEFAULT_OPA_STARTUP_TIMEOUT
+
+// how long we wait for OPA to exit after asking nicely, before we kill it
+var OpaShutdownTimeout = DEFAULT_OPA_SHUTDOWN_TIMEOUT
+
+// serve the bundle to OPA over HTTP (the way it's loaded in production), instead of -b
+var BundleServer bool = false
+
+// how often OPA polls for a new bundle, when it loads bundles from a server
+var BundlePollingInterval = DEFAULT_BUNDLE_POLLING_INTERVAL
+
 // I'm not sure if there's a more elegant way to handle this, it's a local tmp directory for now
 // I'm overcomplicating this, just use the filename, if the caller wants to do something
 // sophisticated, that's a problem to be solved later
@@ -46,6 +65,24 @@ var DecisionFile = DEFAULT_DECISION_ARRAY_FILE
 // performance
 var PerformanceMetrics bool = false
 
+// ask OPA for its own timers and counters with every request
+var OpaMetrics bool = false
+
+// replay every request in-process to collect line coverage of the policy
+var Coverage bool = false
+
+// write the coverage as an lcov tracefile too
+var CoverageLcov = ""
+
+// when a test fails, ask OPA to explain the decision: off, notes, fails or full
+var Explain = "off"
+
+// how many groups of suites (one OPA per group) we run at the same time
+var ParallelSuites int = 1
+
+// how many tests within a suite we send to OPA at the same time
+var Concurrency int = 1
+
 // environment property substitution
 var Resolver *PropertyResolver
 
@@ -5,16 +5,39 @@ Copyright  © 2025 PACLabs
 package config
 
 import (
+	"bufio"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
+const DEFAULT_PROFILE = "default"
+
+/*
+ *  Properties come from several places. From highest to lowest precedence:
+ *
+ *    1. -D KEY=VALUE pairs on the command line
+ *    2. property files, either named with --properties or selected with --profile
+ *    3. environment variables
+ *    4. the 'default' profile, if a profiles file exists
+ */
 type PropertyResolver struct {
-	props map[string]string
+	props     map[string]string
+	fileProps map[string]string
+	defaults  map[string]string
+	parent    *PropertyResolver
 }
 
 func NewPropertyResolver() *PropertyResolver {
-	return &PropertyResolver{props: make(map[string]string)}
+	return &PropertyResolver{
+		props:     make(map[string]string),
+		fileProps: make(map[string]string),
+		defaults:  make(map[string]string),
+	}
 }
 
 // Parse -D flags from cobra's args
@@ -51,21 +74,305 @@ func (pr *PropertyResolver) AddProperty(key string, value string) {
 	pr.props[key] = value
 }
 
-// Resolve with precedence: command line flags > env vars > original token
+/*
+ *  A scope holds properties that only make sense for one test (or scenario), like
+ *  ${RAYGUN_GENERATED_JWT}. Anything not found in the scope is resolved by the
+ *  parent, so tests running concurrently never see each other's properties, and
+ *  never write to the shared resolver
+ */
+func (pr *PropertyResolver) NewScope() *PropertyResolver {
+	scope := NewPropertyResolver()
+	scope.parent = pr
+	return scope
+}
+
+/*
+ *  Load a single .properties, .env or YAML file. Files loaded later override
+ *  files loaded earlier, but never override -D properties
+ */
+func (pr *PropertyResolver) LoadPropertyFile(filename string) error {
+
+	props, err := ReadPropertyFile(filename)
+
+	if err != nil {
+		return err
+	}
+
+	for k, v := range props {
+		pr.fileProps[k] = v
+	}
+
+	return nil
+}
+
+/*
+ *  A profiles file is a YAML map of profile name to a list of property files:
+ *
+ *    default:
+ *      - common.properties
+ *    staging:
+ *      - staging.properties
+ *      - staging.env
+ *
+ *  The 'default' profile is always loaded (at the lowest precedence) when the
+ *  profiles file exists. The named profile, if any, is loaded at the same
+ *  precedence as --properties files. Relative file names are resolved against
+ *  the directory containing the profiles file.
+ */
+func (pr *PropertyResolver) LoadProfile(profiles_filename string, profile string) error {
+
+	data, err := os.ReadFile(profiles_filename)
+
+	if err != nil {
+		if os.IsNotExist(err) && profile == "" {
+			// no profiles file, and no profile requested, so there's nothing to do
+			return nil
+		}
+		return fmt.Errorf("unable to read profiles file: %w", err)
+	}
+
+	profiles := make(map[string][]string)
+
+	err = yaml.Unmarshal(data, &profiles)
+	if err != nil {
+		return fmt.Errorf("unable to parse profiles file %s: %w", profiles_filename, err)
+	}
+
+	base_directory := filepath.Dir(profiles_filename)
+
+	for _, file := range profiles[DEFAULT_PROFILE] {
+		props, err := ReadPropertyFile(resolveRelative(base_directory, file))
+		if err != nil {
+			return err
+		}
+
+		for k, v := range props {
+			pr.defaults[k] = v
+		}
+	}
+
+	if profile == "" || profile == DEFAULT_PROFILE {
+		return nil
+	}
+
+	file_list, found := profiles[profile]
+
+	if !found {
+		return fmt.Errorf("profile %s not found in %s", profile, profiles_filename)
+	}
+
+	for _, file := range file_list {
+		err := pr.LoadPropertyFile(resolveRelative(base_directory, file))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Resolve with precedence: command line flags > property files > env vars > defaults > original token
 func (pr *PropertyResolver) CreatePropertyMap(key string) string {
+	if val, found := pr.lookup(key); found {
+		return val
+	}
+	// Keep original token
+	return "${" + key + "}"
+}
+
+func (pr *PropertyResolver) lookup(key string) (string, bool) {
 	// Check -D properties first
 	if val, ok := pr.props[key]; ok {
-		return val
+		return val, true
+	}
+	// a scope only holds its own properties, everything else comes from the parent
+	if pr.parent != nil {
+		return pr.parent.lookup(key)
+	}
+	// then anything loaded from a property file or profile
+	if val, ok := pr.fileProps[key]; ok {
+		return val, true
 	}
 	// Fall back to environment
 	if val := os.Getenv(key); val != "" {
-		return val
+		return val, true
 	}
-	// Keep original token
-	return "${" + key + "}"
+	// then the default profile
+	if val, ok := pr.defaults[key]; ok {
+		return val, true
+	}
+	return "", false
 }
 
 // Replace all tokens in a string
 func (pr *PropertyResolver) ExpandProperties(tokenizedStr string) string {
 	return os.Expand(tokenizedStr, pr.CreatePropertyMap)
 }
+
+/*
+ *  Replace the ${NAME} and $NAME tokens that resolve, and leave everything else
+ *  exactly as it was written: unresolved tokens, $$, a $ on its own. Suite files
+ *  are expanded this way, so an expectation that happens to contain a $ still
+ *  compares against what's in the file
+ */
+func (pr *PropertyResolver) ExpandKnownProperties(tokenizedStr string) string {
+
+	var sb strings.Builder
+
+	for i := 0; i < len(tokenizedStr); i++ {
+
+		if tokenizedStr[i] != '$' || i+1 == len(tokenizedStr) {
+			sb.WriteByte(tokenizedStr[i])
+			continue
+		}
+
+		name, width := "", 0
+
+		if tokenizedStr[i+1] == '{' {
+			end := strings.IndexByte(tokenizedStr[i+2:], '}')
+			if end > 0 {
+				name, width = tokenizedStr[i+2:i+2+end], end+3
+			}
+		} else {
+			end := i + 1
+			for end < len(tokenizedStr) && isPropertyNameChar(tokenizedStr[end], end == i+1) {
+				end++
+			}
+			name, width = tokenizedStr[i+1:end], end-i
+		}
+
+		value, found := "", false
+		if name != "" {
+			value, found = pr.lookup(name)
+		}
+
+		if !found {
+			sb.WriteByte(tokenizedStr[i])
+			continue
+		}
+
+		sb.WriteString(value)
+		i += width - 1
+	}
+
+	return sb.String()
+}
+
+func isPropertyNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+/*
+ *  Read a property file into a map. The format is chosen by the file name:
+ *
+ *    .properties     - java style key=value (or key: value) pairs
+ *    .env, .env.*    - dotenv style KEY=VALUE pairs, with optional export and quotes
+ *    .yaml, .yml     - a YAML map. Nested maps are flattened with '.' separators
+ */
+func ReadPropertyFile(filename string) (map[string]string, error) {
+
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read property file: %w", err)
+	}
+
+	base := filepath.Base(filename)
+
+	switch {
+	case filepath.Ext(base) == ".properties":
+		return parseKeyValueLines(string(data), false), nil
+	case filepath.Ext(base) == ".env" || strings.HasPrefix(base, ".env"):
+		return parseKeyValueLines(string(data), true), nil
+	case filepath.Ext(base) == ".yaml" || filepath.Ext(base) == ".yml":
+		tree := make(map[string]interface{})
+		err = yaml.Unmarshal(data, &tree)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse property file %s: %w", filename, err)
+		}
+		props := make(map[string]string)
+		flattenYamlProperties("", tree, props)
+		return props, nil
+	default:
+		return nil, fmt.Errorf("unsupported property file type: %s (expecting .properties, .env or .yaml)", filename)
+	}
+}
+
+/*
+ *  .properties and .env files are close enough that one parser handles both.
+ *  dotenv files may prefix lines with 'export' and quote their values
+ */
+func parseKeyValueLines(data string, dotenv bool) map[string]string {
+
+	props := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		if dotenv {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		}
+
+		separator := strings.IndexAny(line, "=:")
+		if dotenv {
+			separator = strings.Index(line, "=")
+		}
+
+		if separator < 1 {
+			continue
+		}
+
+		key := strings.TrimSpace(line[:separator])
+		value := strings.TrimSpace(line[separator+1:])
+
+		if dotenv && len(value) >= 2 {
+			if (value[0] == '"' && value[len(value)-1] == '"') || (value[0] == '\'' && value[len(value)-1] == '\'') {
+				value = value[1 : len(value)-1]
+			}
+		}
+
+		props[key] = value
+	}
+
+	return props
+}
+
+func flattenYamlProperties(prefix string, tree map[string]interface{}, props map[string]string) {
+
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch v := tree[k].(type) {
+		case map[string]interface{}:
+			flattenYamlProperties(key, v, props)
+		case nil:
+			props[key] = ""
+		default:
+			props[key] = fmt.Sprintf("%v", v)
+		}
+	}
+}
+
+func resolveRelative(directory string, filename string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(directory, filename)
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -72,3 +73,107 @@ func TestMultiple_Substitution(t *testing.T) {
 		t.Errorf("Expected property substitution. Expected: 456, got: %s", result)
 	}
 }
+
+func TestPropertyFile_Formats(t *testing.T) {
+
+	dir := t.TempDir()
+
+	os.WriteFile(filepath.Join(dir, "test.properties"), []byte("# comment\nPROP_ONE=one\nPROP_TWO : two\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "test.env"), []byte("export ENV_ONE=\"one\"\nENV_TWO='two'\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "test.yaml"), []byte("yaml:\n  one: 1\n  two: two\n"), 0644)
+
+	resolver := NewPropertyResolver()
+
+	for _, name := range []string{"test.properties", "test.env", "test.yaml"} {
+		if err := resolver.LoadPropertyFile(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("unable to load %s: %v", name, err)
+		}
+	}
+
+	result := resolver.ExpandProperties("${PROP_ONE} ${PROP_TWO} ${ENV_ONE} ${ENV_TWO} ${yaml.one} ${yaml.two}")
+
+	if result != "one two one two 1 two" {
+		t.Errorf("Expected property file substitution. Expected: one two one two 1 two, got: %s", result)
+	}
+}
+
+func TestProfile_Precedence(t *testing.T) {
+
+	dir := t.TempDir()
+
+	os.WriteFile(filepath.Join(dir, "defaults.properties"), []byte("LEVEL=default\nDEFAULT_ONLY=default\nPROFILE_ENV=default\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "staging.properties"), []byte("LEVEL=staging\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "profiles.yaml"), []byte("default:\n  - defaults.properties\nstaging:\n  - staging.properties\n"), 0644)
+
+	t.Setenv("PROFILE_ENV", "environment")
+
+	resolver := NewPropertyResolver()
+
+	if err := resolver.LoadProfile(filepath.Join(dir, "profiles.yaml"), "staging"); err != nil {
+		t.Fatalf("unable to load profile: %v", err)
+	}
+
+	result := resolver.ExpandProperties("${LEVEL} ${DEFAULT_ONLY} ${PROFILE_ENV}")
+
+	if result != "staging default environment" {
+		t.Errorf("Expected profile precedence. Expected: staging default environment, got: %s", result)
+	}
+
+	resolver.AddProperty("LEVEL", "command-line")
+
+	result = resolver.ExpandProperties("${LEVEL}")
+
+	if result != "command-line" {
+		t.Errorf("Expected -D to override profile. Expected: command-line, got: %s", result)
+	}
+
+	if err := resolver.LoadProfile(filepath.Join(dir, "profiles.yaml"), "production"); err == nil {
+		t.Errorf("Expected an error for an unknown profile")
+	}
+}
+
+func TestScope_Isolation(t *testing.T) {
+
+	resolver := NewPropertyResolver()
+	resolver.AddProperty("SHARED", "shared")
+
+	first := resolver.NewScope()
+	second := resolver.NewScope()
+
+	first.AddProperty("RAYGUN_GENERATED_JWT", "first-jwt")
+
+	result := first.ExpandProperties("${SHARED} ${RAYGUN_GENERATED_JWT}")
+	if result != "shared first-jwt" {
+		t.Errorf("Expected scope and parent substitution. Expected: shared first-jwt, got: %s", result)
+	}
+
+	result = second.ExpandProperties("${RAYGUN_GENERATED_JWT}")
+	if result != "${RAYGUN_GENERATED_JWT}" {
+		t.Errorf("Expected scopes to be isolated. Expected: ${RAYGUN_GENERATED_JWT}, got: %s", result)
+	}
+
+	result = resolver.ExpandProperties("${RAYGUN_GENERATED_JWT}")
+	if result != "${RAYGUN_GENERATED_JWT}" {
+		t.Errorf("Expected the parent to be untouched. Expected: ${RAYGUN_GENERATED_JWT}, got: %s", result)
+	}
+}
+
+func TestKnown_Substitution(t *testing.T) {
+
+	resolver := NewPropertyResolver()
+	resolver.AddProperty("TENANT", "acme")
+
+	tests := map[string]string{
+		"/v1/data/${TENANT}/allow": "/v1/data/acme/allow",
+		"$TENANT-$TENANT":          "acme-acme",
+		"costs $$5 or ${UNKNOWN}":  "costs $$5 or ${UNKNOWN}",
+		"$foo and $ and ${}":       "$foo and $ and ${}",
+		"${TENANT":                 "${TENANT",
+	}
+
+	for input, expected := range tests {
+		if result := resolver.ExpandKnownProperties(input); result != expected {
+			t.Errorf("%q: expected %q, got %q", input, expected, result)
+		}
+	}
+}
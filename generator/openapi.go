@@ -0,0 +1,395 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package generator
+
+/*
+ *  Generates a skeleton .raygun suite from an OpenAPI (v3, or Swagger v2) document.
+ *
+ *  API gateway policies tend to mirror the API surface, so we create a test for every
+ *  path and method. Operations that declare a security requirement get two tests: one
+ *  with credentials (expected to be allowed) and one without (expected to be denied).
+ *
+ *  The output is a starting point - the generated credentials are ${} properties, and
+ *  the expectations are a guess based on the declared security requirements. Bearer
+ *  tokens are JWTs raygun generates, signed with the ${JWT_SECRET} property.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"raygun/types"
+	"raygun/util"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the signing key of the generated JWTs, until the tester replaces the jwt: section
+const JWT_SECRET_PROPERTY = "${JWT_SECRET}"
+
+// the order we emit methods in, so the output is stable from run to run
+var HTTP_METHODS = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+var pathParameterPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+type OpenApiGenerator struct {
+	DecisionPath string
+	BundlePath   string
+	InputStyle   string // envoy or http
+	SuiteName    string
+}
+
+/*
+ *  The shape of the generated file. We don't marshal types.TestSuite directly,
+ *  since it carries parser state that doesn't belong in a .raygun file
+ */
+type generatedSuite struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description,omitempty"`
+	Opa         map[string]string `yaml:"opa,omitempty"`
+	Jwt         *generatedJwt     `yaml:"jwt,omitempty"`
+	Tests       []generatedTest   `yaml:"tests"`
+}
+
+type generatedJwt struct {
+	Algorithm string `yaml:"algorithm,omitempty"`
+	Secret    string `yaml:"secret,omitempty"`
+	Active    bool   `yaml:"active,omitempty"`
+}
+
+type generatedTest struct {
+	Name         string                   `yaml:"name"`
+	Description  string                   `yaml:"description,omitempty"`
+	DecisionPath string                   `yaml:"decision-path"`
+	Expects      []map[string]interface{} `yaml:"expects"`
+	Input        generatedInput           `yaml:"input"`
+	Jwt          *generatedJwt            `yaml:"jwt,omitempty"`
+}
+
+type generatedInput struct {
+	InputType string             `yaml:"type"`
+	Value     string             `yaml:"value,omitempty"`
+	Request   *types.HttpRequest `yaml:"request,omitempty"`
+}
+
+func NewOpenApiGenerator(decision_path string) *OpenApiGenerator {
+	return &OpenApiGenerator{DecisionPath: decision_path, InputStyle: "envoy"}
+}
+
+/*
+ *  Read the OpenAPI document and return the text of the generated suite
+ */
+func (g *OpenApiGenerator) Generate(spec_filename string) (string, error) {
+
+	if g.InputStyle != "envoy" && g.InputStyle != "http" {
+		return "", fmt.Errorf("unsupported input style: %s (expecting envoy or http)", g.InputStyle)
+	}
+
+	data, err := os.ReadFile(spec_filename)
+	if err != nil {
+		return "", fmt.Errorf("unable to read OpenAPI document: %w", err)
+	}
+
+	// YAML is a superset of JSON, so this handles both
+	spec := make(map[string]interface{})
+	err = yaml.Unmarshal(data, &spec)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse OpenAPI document %s: %w", spec_filename, err)
+	}
+
+	paths, ok := spec["paths"].(map[string]interface{})
+	if !ok || len(paths) == 0 {
+		return "", fmt.Errorf("OpenAPI document %s has no paths", spec_filename)
+	}
+
+	suite := generatedSuite{Name: g.SuiteName}
+
+	if info, ok := spec["info"].(map[string]interface{}); ok {
+		if suite.Name == "" {
+			suite.Name = fmt.Sprintf("%v", info["title"])
+		}
+		suite.Description = fmt.Sprintf("Generated from %s version %v", spec_filename, info["version"])
+	}
+
+	if suite.Name == "" {
+		suite.Name = spec_filename
+	}
+
+	if g.BundlePath != "" {
+		suite.Opa = map[string]string{"bundle-path": g.BundlePath}
+	}
+
+	schemes := securitySchemes(spec)
+	global_security, _ := spec["security"].([]interface{})
+
+	for _, path := range util.SortMapKeys(paths) {
+
+		path_item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, method := range HTTP_METHODS {
+
+			operation, ok := path_item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			security := global_security
+			if operation_security, found := operation["security"]; found {
+				security, _ = operation_security.([]interface{})
+			}
+
+			concrete_path := concretePath(path, path_item, operation)
+			description := operationDescription(operation)
+
+			request := types.HttpRequest{Method: strings.ToUpper(method), Path: concrete_path}
+
+			if !requiresCredentials(security) {
+				test, err := g.createTest(fmt.Sprintf("%s %s", request.Method, path), description, request, true)
+				if err != nil {
+					return "", err
+				}
+				suite.Tests = append(suite.Tests, test)
+				continue
+			}
+
+			authorized := request
+			bearer := addCredentials(&authorized, security, schemes)
+
+			test, err := g.createTest(fmt.Sprintf("%s %s (allowed)", request.Method, path), description, authorized, true)
+			if err != nil {
+				return "", err
+			}
+
+			if bearer {
+				// raygun generates the token for ${RAYGUN_GENERATED_JWT}
+				test.Jwt = &generatedJwt{Active: true}
+				suite.Jwt = &generatedJwt{Algorithm: "HS256", Secret: JWT_SECRET_PROPERTY}
+			}
+			suite.Tests = append(suite.Tests, test)
+
+			test, err = g.createTest(fmt.Sprintf("%s %s (denied, no credentials)", request.Method, path), description, request, false)
+			if err != nil {
+				return "", err
+			}
+			suite.Tests = append(suite.Tests, test)
+		}
+	}
+
+	var buffer bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+
+	err = encoder.Encode(suite)
+	if err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+func (g *OpenApiGenerator) createTest(name string, description string, request types.HttpRequest, allowed bool) (generatedTest, error) {
+
+	test := generatedTest{
+		Name:         name,
+		Description:  description,
+		DecisionPath: g.DecisionPath,
+		Expects:      []map[string]interface{}{{"allowed": allowed}},
+	}
+
+	if g.InputStyle == "envoy" {
+		test.Input = generatedInput{InputType: "http-request", Request: &request}
+		return test, nil
+	}
+
+	// a generic HTTP shape, for policies that aren't behind Envoy
+	http := map[string]interface{}{"method": request.Method, "path": request.Path}
+	if len(request.Headers) > 0 {
+		http["headers"] = request.Headers
+	}
+	if len(request.Query) > 0 {
+		http["query"] = request.Query
+	}
+
+	b, err := json.MarshalIndent(http, "", "  ")
+	if err != nil {
+		return test, err
+	}
+
+	test.Input = generatedInput{InputType: "inline", Value: string(b)}
+
+	return test, nil
+}
+
+/*
+ *  OpenAPI 3 keeps these in components, Swagger 2 in securityDefinitions
+ */
+func securitySchemes(spec map[string]interface{}) map[string]interface{} {
+
+	if components, ok := spec["components"].(map[string]interface{}); ok {
+		if schemes, ok := components["securitySchemes"].(map[string]interface{}); ok {
+			return schemes
+		}
+	}
+
+	if schemes, ok := spec["securityDefinitions"].(map[string]interface{}); ok {
+		return schemes
+	}
+
+	return map[string]interface{}{}
+}
+
+/*
+ *  security: [] (or a requirement of {}) means the operation is public
+ */
+func requiresCredentials(security []interface{}) bool {
+
+	if len(security) == 0 {
+		return false
+	}
+
+	for _, requirement := range security {
+		if requirement_map, ok := requirement.(map[string]interface{}); ok && len(requirement_map) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+ *  Satisfy the first security requirement. Credentials are ${} properties, so the
+ *  tester can supply real values with -D or a property file. true if the request
+ *  carries a generated bearer token
+ */
+func addCredentials(request *types.HttpRequest, security []interface{}, schemes map[string]interface{}) bool {
+
+	requirement, ok := security[0].(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	bearer := false
+
+	request.Headers = make(map[string]string)
+	request.Query = make(map[string]interface{})
+
+	for _, scheme_name := range util.SortMapKeys(requirement) {
+
+		scheme, _ := schemes[scheme_name].(map[string]interface{})
+		property := "${" + propertyName(scheme_name) + "}"
+
+		scheme_type, _ := scheme["type"].(string)
+
+		switch scheme_type {
+		case "apiKey":
+			name, _ := scheme["name"].(string)
+			if scheme["in"] == "query" {
+				request.Query[name] = property
+			} else {
+				request.Headers[strings.ToLower(name)] = property
+			}
+		case "http", "basic":
+			if strings.EqualFold(fmt.Sprintf("%v", scheme["scheme"]), "basic") || scheme_type == "basic" {
+				request.Headers["authorization"] = "Basic " + property
+			} else {
+				request.Headers["authorization"] = "Bearer ${RAYGUN_GENERATED_JWT}"
+				bearer = true
+			}
+		default:
+			// oauth2, openIdConnect and anything we don't recognize get a bearer token
+			request.Headers["authorization"] = "Bearer ${RAYGUN_GENERATED_JWT}"
+			bearer = true
+		}
+	}
+
+	if len(request.Query) == 0 {
+		request.Query = nil
+	}
+
+	return bearer
+}
+
+/*
+ *  apiKeyAuth -> API_KEY_AUTH
+ */
+func propertyName(scheme_name string) string {
+
+	var sb strings.Builder
+
+	for i, r := range scheme_name {
+		if r >= 'A' && r <= 'Z' && i > 0 {
+			sb.WriteRune('_')
+		}
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+
+	return strings.ToUpper(sb.String())
+}
+
+/*
+ *  Replace {id} style parameters with an example value from the parameter
+ *  definition, or a placeholder based on its type
+ */
+func concretePath(path string, path_item map[string]interface{}, operation map[string]interface{}) string {
+
+	examples := make(map[string]string)
+
+	parameters := make([]interface{}, 0)
+	if p, ok := path_item["parameters"].([]interface{}); ok {
+		parameters = append(parameters, p...)
+	}
+	if p, ok := operation["parameters"].([]interface{}); ok {
+		parameters = append(parameters, p...)
+	}
+
+	for _, element := range parameters {
+
+		parameter, ok := element.(map[string]interface{})
+		if !ok || parameter["in"] != "path" {
+			continue
+		}
+
+		name := fmt.Sprintf("%v", parameter["name"])
+		schema, _ := parameter["schema"].(map[string]interface{})
+
+		switch {
+		case parameter["example"] != nil:
+			examples[name] = fmt.Sprintf("%v", parameter["example"])
+		case schema != nil && schema["example"] != nil:
+			examples[name] = fmt.Sprintf("%v", schema["example"])
+		case (schema != nil && schema["type"] == "integer") || parameter["type"] == "integer":
+			examples[name] = "1"
+		}
+	}
+
+	return pathParameterPattern.ReplaceAllStringFunc(path, func(match string) string {
+		name := match[1 : len(match)-1]
+		if example, found := examples[name]; found {
+			return example
+		}
+		return "example-" + name
+	})
+}
+
+func operationDescription(operation map[string]interface{}) string {
+
+	for _, key := range []string{"summary", "operationId", "description"} {
+		if v, ok := operation[key].(string); ok && v != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+
+	return ""
+}
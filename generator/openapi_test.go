@@ -0,0 +1,74 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const petStore = `
+openapi: 3.0.0
+info:
+  title: Pets
+  version: "1.0"
+security:
+  - bearerAuth: []
+components:
+  securitySchemes:
+    bearerAuth:
+      type: http
+      scheme: bearer
+paths:
+  /pets/{petId}:
+    get:
+      parameters:
+        - name: petId
+          in: path
+          schema:
+            type: integer
+  /health:
+    get:
+      security: []
+`
+
+func TestOpenApi_AllowedAndDeniedVariants(t *testing.T) {
+
+	spec := filepath.Join(t.TempDir(), "spec.yaml")
+	os.WriteFile(spec, []byte(petStore), 0644)
+
+	suite, err := NewOpenApiGenerator("/v1/data/envoy/authz/allow").Generate(spec)
+	if err != nil {
+		t.Fatalf("unable to generate suite: %v", err)
+	}
+
+	expected := []string{
+		"name: GET /health\n",
+		"name: GET /pets/{petId} (allowed)\n",
+		"name: GET /pets/{petId} (denied, no credentials)\n",
+		"path: /pets/1\n",
+		"authorization: Bearer ${RAYGUN_GENERATED_JWT}\n",
+	}
+
+	for _, fragment := range expected {
+		if !strings.Contains(suite, fragment) {
+			t.Errorf("expected the generated suite to contain %q, got:\n%s", fragment, suite)
+		}
+	}
+
+	if strings.Count(suite, "authorization:") != 1 {
+		t.Errorf("expected only the allowed variant to carry credentials, got:\n%s", suite)
+	}
+
+	// the token comes from the suite's jwt: section, signed with a property
+	if !strings.Contains(suite, "jwt:\n  algorithm: HS256\n  secret: ${JWT_SECRET}\n") {
+		t.Errorf("expected a suite jwt section, got:\n%s", suite)
+	}
+
+	if strings.Count(suite, "active: true") != 1 {
+		t.Errorf("expected the allowed variant to generate a JWT, got:\n%s", suite)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"os"
 	"raygun/config"
+	"sync"
 )
 
 func Verbose(format string, a ...any) {
@@ -53,11 +54,35 @@ func Error(format string, a ...any) {
 	}
 }
 
+/*
+ *  Cleanup that has to happen even when we exit via Fatal, like stopping
+ *  the OPA process we started
+ */
+var exitHooks []func()
+var exitHooksLock sync.Mutex
+
+func RegisterExitHook(hook func()) {
+	exitHooksLock.Lock()
+	defer exitHooksLock.Unlock()
+
+	exitHooks = append(exitHooks, hook)
+}
+
 /*
  *  Unlike the others, fatal the program after printing the fatal error
  */
 func Fatal(format string, a ...any) {
 	err_msg := fmt.Sprintf(format, a...)
 	fmt.Printf("FATAL: %s\n", err_msg)
+
+	// a hook may take a while, so it runs without holding the lock
+	exitHooksLock.Lock()
+	hooks := append([]func(){}, exitHooks...)
+	exitHooksLock.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
 	os.Exit(-1)
 }
@@ -0,0 +1,156 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  Builds a bundle from a directory of .rego and data files (like opa build), so
+ *  testers don't need a build_bundle.sh, and can't forget to run it.
+ *
+ *  Bundles are cached in the temp directory by a hash of the source files, the
+ *  roots and the revision. An unchanged source directory isn't rebuilt, and a
+ *  changed one never gets a stale bundle.
+ */
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"raygun/log"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/compile"
+)
+
+const BUNDLE_CACHE_DIRECTORY = "raygun-bundles"
+
+/*
+ *  Returns the path of the (possibly cached) bundle built from source_dir
+ */
+func BuildBundle(source_dir string, roots []string, revision string) (string, error) {
+
+	info, err := os.Stat(source_dir)
+	if err != nil {
+		return "", fmt.Errorf("unable to read bundle source directory: %w", err)
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("bundle source %s is not a directory", source_dir)
+	}
+
+	hash, err := hashBundleSource(source_dir, roots, revision)
+	if err != nil {
+		return "", err
+	}
+
+	cache_directory := filepath.Join(os.TempDir(), BUNDLE_CACHE_DIRECTORY)
+
+	err = os.MkdirAll(cache_directory, 0755)
+	if err != nil {
+		return "", fmt.Errorf("unable to create bundle cache directory: %w", err)
+	}
+
+	bundle_path := filepath.Join(cache_directory, hash+".tar.gz")
+
+	if _, err := os.Stat(bundle_path); err == nil {
+		log.Debug("Using cached bundle %s for %s", bundle_path, source_dir)
+		return bundle_path, nil
+	}
+
+	log.Debug("Building bundle %s from %s", bundle_path, source_dir)
+
+	// build to a temp file, and rename it into place, so a suite running in parallel
+	// never sees a half written bundle
+	temp_file, err := os.CreateTemp(cache_directory, hash+"-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("unable to create bundle file: %w", err)
+	}
+
+	defer os.Remove(temp_file.Name())
+
+	compiler := compile.New().
+		WithPaths(source_dir).
+		WithRoots(roots...).
+		WithRevision(revision).
+		WithRegoVersion(ast.RegoV1).
+		WithOutput(temp_file)
+
+	err = compiler.Build(context.Background())
+
+	close_err := temp_file.Close()
+
+	if err != nil {
+		return "", fmt.Errorf("unable to build bundle from %s: %w", source_dir, err)
+	}
+
+	if close_err != nil {
+		return "", close_err
+	}
+
+	err = os.Rename(temp_file.Name(), bundle_path)
+	if err != nil {
+		return "", fmt.Errorf("unable to save bundle: %w", err)
+	}
+
+	return bundle_path, nil
+}
+
+/*
+ *  Every file in the source directory counts, along with its path, since moving a
+ *  data file moves its data
+ */
+func hashBundleSource(source_dir string, roots []string, revision string) (string, error) {
+
+	files := make([]string, 0)
+
+	err := filepath.WalkDir(source_dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("unable to read bundle source directory: %w", err)
+	}
+
+	sort.Strings(files)
+
+	hash := sha256.New()
+
+	fmt.Fprintf(hash, "revision:%s\nroots:%s\n", revision, strings.Join(roots, ","))
+
+	for _, path := range files {
+
+		relative, err := filepath.Rel(source_dir, path)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(hash, "file:%s\n", filepath.ToSlash(relative))
+
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = io.Copy(hash, f)
+		f.Close()
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-policy-agent/opa/v1/loader"
+)
+
+func TestBuildBundle_Caching(t *testing.T) {
+
+	// keep the cache out of the real temp directory
+	t.Setenv("TMPDIR", t.TempDir())
+
+	source_dir := writeTestBundle(t)
+
+	first, err := BuildBundle(source_dir, []string{"test", "limits"}, "rev-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := BuildBundle(source_dir, []string{"test", "limits"}, "rev-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Errorf("unchanged source was rebuilt: %s != %s", first, second)
+	}
+
+	b, err := loader.NewFileLoader().AsBundle(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Manifest.Revision != "rev-1" {
+		t.Errorf("unexpected revision: %s", b.Manifest.Revision)
+	}
+
+	if b.Manifest.Roots == nil || len(*b.Manifest.Roots) != 2 {
+		t.Errorf("unexpected roots: %v", b.Manifest.Roots)
+	}
+
+	// a different revision, or a changed file, is a different bundle
+	third, err := BuildBundle(source_dir, []string{"test", "limits"}, "rev-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(source_dir, "data.json"), []byte(`{"limits": {"ray": 4}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fourth, err := BuildBundle(source_dir, []string{"test", "limits"}, "rev-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if third == first || fourth == first || third == fourth {
+		t.Errorf("expected distinct bundles: %s %s %s", first, third, fourth)
+	}
+}
+
+func TestBuildBundle_NotADirectory(t *testing.T) {
+
+	_, err := BuildBundle(filepath.Join(t.TempDir(), "missing"), nil, "")
+	if err == nil {
+		t.Errorf("expected an error for a missing source directory")
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  What's in a bundle: its manifest, data files and packages. We read these from
+ *  the bundle file, or ask a running OPA about the bundle it has activated
+ */
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type BundleReport struct {
+	Name      string
+	Roots     []string
+	Revision  string
+	Metadata  map[string]interface{}
+	DataFiles []string // nil when we can't tell (OPA doesn't report them)
+	Packages  []string // without the data. prefix, e.g. envoy.authz
+}
+
+var DATA_FILE_NAMES = []string{"data.json", "data.yaml", "data.yml"}
+
+/*
+ *  Read a bundle file (a tar.gz, or a directory)
+ */
+func InspectBundle(bundle_path string) (BundleReport, error) {
+
+	report := BundleReport{Name: bundle_path}
+
+	b, err := loadBundle(bundle_path, nil)
+	if err != nil {
+		return report, err
+	}
+
+	if b.Manifest.Roots != nil {
+		report.Roots = append([]string{}, *b.Manifest.Roots...)
+	}
+
+	report.Revision = b.Manifest.Revision
+	report.Metadata = b.Manifest.Metadata
+
+	for _, module := range b.Modules {
+		report.Packages = appendPackage(report.Packages, module.Parsed.Package.Path.String())
+	}
+
+	report.DataFiles, err = bundleDataFiles(bundle_path)
+	if err != nil {
+		return report, err
+	}
+
+	sort.Strings(report.Packages)
+
+	return report, nil
+}
+
+/*
+ *  Ask OPA about an active bundle: the manifest from /v1/data/system/bundles, and
+ *  the packages from /v1/policies, which lists every bundle's policies under ids
+ *  like <bundle-name>/<path>. If OPA has one bundle, the name can be empty
+ */
+func (opa *OpaRunner) InspectActiveBundle(name string) (BundleReport, error) {
+
+	report := BundleReport{Name: name}
+
+	client := http.Client{Timeout: 5 * time.Second}
+
+	var bundles struct {
+		Result map[string]struct {
+			Manifest struct {
+				Revision string                 `json:"revision"`
+				Roots    []string               `json:"roots"`
+				Metadata map[string]interface{} `json:"metadata"`
+			} `json:"manifest"`
+		} `json:"result"`
+	}
+
+	err := getJson(client, opa.Config.GetAgentUrl()+"/v1/data/system/bundles", &bundles)
+	if err != nil {
+		return report, fmt.Errorf("unable to read OPA's bundles: %w", err)
+	}
+
+	if name == "" {
+
+		if len(bundles.Result) != 1 {
+			names := make([]string, 0, len(bundles.Result))
+			for n := range bundles.Result {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return report, fmt.Errorf("OPA has %d bundles %v, so bundle-name is needed to pick one", len(names), names)
+		}
+
+		for n := range bundles.Result {
+			name = n
+		}
+	}
+
+	active, found := bundles.Result[name]
+	if !found {
+		return report, fmt.Errorf("OPA has no active bundle named %s", name)
+	}
+
+	report.Name = name
+	report.Roots = active.Manifest.Roots
+	report.Revision = active.Manifest.Revision
+	report.Metadata = active.Manifest.Metadata
+
+	var policies struct {
+		Result []struct {
+			Id  string `json:"id"`
+			Ast struct {
+				Package struct {
+					Path []struct {
+						Value interface{} `json:"value"`
+					} `json:"path"`
+				} `json:"package"`
+			} `json:"ast"`
+		} `json:"result"`
+	}
+
+	err = getJson(client, opa.Config.GetAgentUrl()+"/v1/policies", &policies)
+	if err != nil {
+		return report, fmt.Errorf("unable to read OPA's policies: %w", err)
+	}
+
+	for _, policy := range policies.Result {
+
+		if !strings.HasPrefix(policy.Id, name+"/") {
+			continue
+		}
+
+		segments := make([]string, 0, len(policy.Ast.Package.Path))
+		for _, term := range policy.Ast.Package.Path {
+			segments = append(segments, fmt.Sprintf("%v", term.Value))
+		}
+
+		report.Packages = appendPackage(report.Packages, strings.Join(segments, "."))
+	}
+
+	sort.Strings(report.Packages)
+
+	return report, nil
+}
+
+/*
+ *  Add a package once, without its data. prefix
+ */
+func appendPackage(packages []string, package_path string) []string {
+
+	package_path = strings.TrimPrefix(package_path, "data.")
+
+	for _, existing := range packages {
+		if existing == package_path {
+			return packages
+		}
+	}
+
+	return append(packages, package_path)
+}
+
+func isDataFile(name string) bool {
+
+	base := filepath.Base(name)
+
+	for _, data_file := range DATA_FILE_NAMES {
+		if base == data_file {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ *  The data files in a bundle, relative to its root
+ */
+func bundleDataFiles(bundle_path string) ([]string, error) {
+
+	data_files := []string{}
+
+	info, err := os.Stat(bundle_path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+
+		err = filepath.WalkDir(bundle_path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && isDataFile(path) {
+				relative, _ := filepath.Rel(bundle_path, path)
+				data_files = append(data_files, filepath.ToSlash(relative))
+			}
+			return nil
+		})
+
+		sort.Strings(data_files)
+
+		return data_files, err
+	}
+
+	f, err := os.Open(bundle_path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("bundle %s is not a tar.gz: %w", bundle_path, err)
+	}
+
+	tar_reader := tar.NewReader(gz)
+
+	for {
+		header, err := tar_reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read bundle %s: %w", bundle_path, err)
+		}
+
+		if header.Typeflag == tar.TypeReg && isDataFile(header.Name) {
+			data_files = append(data_files, strings.TrimPrefix(header.Name, "/"))
+		}
+	}
+
+	sort.Strings(data_files)
+
+	return data_files, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInspectBundle(t *testing.T) {
+
+	t.Setenv("TMPDIR", t.TempDir())
+
+	source_dir := writeTestBundle(t)
+
+	err := os.MkdirAll(filepath.Join(source_dir, "extra"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(source_dir, "extra", "data.yaml"), []byte("extra: true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a directory bundle keeps its data files as they are
+	report, err := InspectBundle(source_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report.DataFiles, []string{"data.json", "extra/data.yaml"}) {
+		t.Errorf("unexpected data files: %v", report.DataFiles)
+	}
+
+	if !reflect.DeepEqual(report.Packages, []string{"test"}) {
+		t.Errorf("unexpected packages: %v", report.Packages)
+	}
+
+	// opa build merges them into one
+	bundle_path, err := BuildBundle(source_dir, []string{"test", "limits", "extra"}, "rev-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = InspectBundle(bundle_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Revision != "rev-3" || !reflect.DeepEqual(report.Roots, []string{"test", "limits", "extra"}) {
+		t.Errorf("unexpected manifest: %s %v", report.Revision, report.Roots)
+	}
+
+	if !reflect.DeepEqual(report.DataFiles, []string{"data.json"}) {
+		t.Errorf("unexpected data files: %v", report.DataFiles)
+	}
+}
+
+func TestInspectActiveBundle_TwoBundles(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/data/system/bundles":
+			w.Write([]byte(`{"result": {
+				"authz": {"manifest": {"revision": "r1", "roots": ["authz"]}},
+				"authz-extra": {"manifest": {"revision": "r2", "roots": ["extra"]}}}}`))
+		case "/v1/policies":
+			w.Write([]byte(`{"result": [
+				{"id": "authz/policy.rego", "ast": {"package": {"path": [{"value": "data"}, {"value": "authz"}]}}},
+				{"id": "authz-extra/policy.rego", "ast": {"package": {"path": [{"value": "data"}, {"value": "extra"}]}}},
+				{"id": "authz-extra/authz/more.rego", "ast": {"package": {"path": [{"value": "data"}, {"value": "extra"}, {"value": "more"}]}}}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	runner := &OpaRunner{Config: OpaConfig{EndpointUrl: server.URL}}
+
+	report, err := runner.InspectActiveBundle("authz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Revision != "r1" || !reflect.DeepEqual(report.Packages, []string{"authz"}) {
+		t.Errorf("expected only the authz bundle's packages, got %s %v", report.Revision, report.Packages)
+	}
+
+	report, err = runner.InspectActiveBundle("authz-extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report.Packages, []string{"extra", "extra.more"}) {
+		t.Errorf("expected only the authz-extra bundle's packages, got %v", report.Packages)
+	}
+
+	// with two bundles, we can't guess which one is meant
+	_, err = runner.InspectActiveBundle("")
+	if err == nil {
+		t.Errorf("expected an error without a bundle name")
+	}
+}
@@ -0,0 +1,301 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  A tiny HTTP bundle server, so OPA can load its bundle the way it does in
+ *  production: from a service, with polling and ETags, instead of from -b.
+ *
+ *  The bundle can be replaced while OPA is running, and the next poll picks it up.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"raygun/log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const BUNDLE_SERVICE_NAME = "raygun"
+const BUNDLE_RESOURCE = "bundles/bundle.tar.gz"
+
+type BundleServer struct {
+	BundlePath string
+	Requests   atomic.Int64 // every bundle request, including the 304s
+	Downloads  atomic.Int64 // just the requests that got the bundle
+	listener   net.Listener
+	server     *http.Server
+	lock       sync.RWMutex
+	data       []byte
+	etag       string
+}
+
+/*
+ *  Start serving the bundle on a free local port
+ */
+func NewBundleServer(bundle_path string) (*BundleServer, error) {
+
+	server := &BundleServer{}
+
+	err := server.Replace(bundle_path)
+	if err != nil {
+		return nil, err
+	}
+
+	server.listener, err = net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the bundle server: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+BUNDLE_RESOURCE, server.serveBundle)
+
+	server.server = &http.Server{Handler: mux}
+
+	go func() {
+		err := server.server.Serve(server.listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error("Bundle server stopped: %s", err.Error())
+		}
+	}()
+
+	log.Debug("Serving bundle %s at %s/%s", bundle_path, server.Url(), BUNDLE_RESOURCE)
+
+	return server, nil
+}
+
+func (server *BundleServer) Url() string {
+	return "http://" + server.listener.Addr().String()
+}
+
+/*
+ *  Serve a different bundle from now on. OPA sees the new ETag on its next poll
+ */
+func (server *BundleServer) Replace(bundle_path string) error {
+
+	data, err := os.ReadFile(bundle_path)
+	if err != nil {
+		return fmt.Errorf("unable to read bundle: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.BundlePath = bundle_path
+	server.data = data
+	server.etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	return nil
+}
+
+func (server *BundleServer) Close() {
+	server.server.Close()
+}
+
+func (server *BundleServer) serveBundle(w http.ResponseWriter, r *http.Request) {
+
+	server.Requests.Add(1)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.lock.RLock()
+	data := server.data
+	etag := server.etag
+	server.lock.RUnlock()
+
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	server.Downloads.Add(1)
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Write(data)
+}
+
+/*
+ *  Write an OPA config file that loads the bundle from a service (with polling),
+ *  instead of from -b. Anything in the suite's own config file is kept, we just
+ *  add our service and bundle to it
+ */
+func writeServiceConfig(base_config_file string, service_url string, resource string, polling_seconds int, signing *SigningConfig) (string, error) {
+
+	document := make(map[string]interface{})
+
+	if base_config_file != "" {
+
+		data, err := os.ReadFile(base_config_file)
+		if err != nil {
+			return "", fmt.Errorf("unable to read OPA config file: %w", err)
+		}
+
+		err = yaml.Unmarshal(data, &document)
+		if err != nil {
+			return "", fmt.Errorf("unable to parse OPA config file %s: %w", base_config_file, err)
+		}
+
+		if document == nil {
+			document = make(map[string]interface{})
+		}
+	}
+
+	if polling_seconds < 1 {
+		polling_seconds = 1
+	}
+
+	services, _ := document["services"].(map[string]interface{})
+	if services == nil {
+		services = make(map[string]interface{})
+	}
+
+	services[BUNDLE_SERVICE_NAME] = map[string]interface{}{"url": service_url}
+	document["services"] = services
+
+	bundles, _ := document["bundles"].(map[string]interface{})
+	if bundles == nil {
+		bundles = make(map[string]interface{})
+	}
+
+	bundle_config := map[string]interface{}{
+		"service":  BUNDLE_SERVICE_NAME,
+		"resource": resource,
+		"polling": map[string]interface{}{
+			"min_delay_seconds": polling_seconds,
+			"max_delay_seconds": polling_seconds,
+		},
+	}
+
+	if signing != nil {
+
+		key_config, signing_config, err := signing.serviceConfig()
+		if err != nil {
+			return "", err
+		}
+
+		keys, _ := document["keys"].(map[string]interface{})
+		if keys == nil {
+			keys = make(map[string]interface{})
+		}
+
+		for id, key := range key_config {
+			keys[id] = key
+		}
+
+		document["keys"] = keys
+		bundle_config["signing"] = signing_config
+	}
+
+	bundles[BUNDLE_SERVICE_NAME] = bundle_config
+	document["bundles"] = bundles
+
+	// the status plugin gives us /v1/status, which reports the active revision.
+	// prometheus is the quietest way to switch it on
+	if _, found := document["status"]; !found {
+		document["status"] = map[string]interface{}{"prometheus": true}
+	}
+
+	data, err := yaml.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp("", "raygun-opa-config-*.yaml")
+	if err != nil {
+		return "", fmt.Errorf("unable to create OPA config file: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug("Generated OPA config %s:\n%s", f.Name(), string(data))
+
+	return f.Name(), nil
+}
+
+/*
+ *  The revision in a bundle's manifest
+ */
+func BundleRevision(bundle_path string) (string, error) {
+
+	b, err := loadBundle(bundle_path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return b.Manifest.Revision, nil
+}
+
+/*
+ *  The revision of our bundle that OPA has activated, from /v1/status. If the status
+ *  plugin isn't enabled, the manifest under /v1/data/system/bundles tells us too
+ */
+func (opa *OpaRunner) ActiveRevision() (string, error) {
+
+	client := http.Client{Timeout: time.Second}
+
+	var status struct {
+		Result struct {
+			Bundles map[string]struct {
+				ActiveRevision string `json:"active_revision"`
+			} `json:"bundles"`
+		} `json:"result"`
+	}
+
+	err := getJson(client, opa.Config.GetAgentUrl()+"/v1/status", &status)
+	if err == nil {
+		return status.Result.Bundles[BUNDLE_SERVICE_NAME].ActiveRevision, nil
+	}
+
+	log.Debug("ActiveRevision: /v1/status failed (%s), trying system/bundles", err.Error())
+
+	var bundles struct {
+		Result map[string]struct {
+			Manifest struct {
+				Revision string `json:"revision"`
+			} `json:"manifest"`
+		} `json:"result"`
+	}
+
+	err = getJson(client, opa.Config.GetAgentUrl()+"/v1/data/system/bundles", &bundles)
+	if err != nil {
+		return "", err
+	}
+
+	return bundles.Result[BUNDLE_SERVICE_NAME].Manifest.Revision, nil
+}
+
+func getJson(client http.Client, url string, target interface{}) error {
+
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, response.StatusCode)
+	}
+
+	return json.NewDecoder(response.Body).Decode(target)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBundleServer_ETag(t *testing.T) {
+
+	directory := t.TempDir()
+
+	first := filepath.Join(directory, "first.tar.gz")
+	second := filepath.Join(directory, "second.tar.gz")
+
+	os.WriteFile(first, []byte("first bundle"), 0644)
+	os.WriteFile(second, []byte("second bundle"), 0644)
+
+	server, err := NewBundleServer(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	get := func(etag string) *http.Response {
+		request, _ := http.NewRequest(http.MethodGet, server.Url()+"/"+BUNDLE_RESOURCE, nil)
+		if etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response
+	}
+
+	response := get("")
+	etag := response.Header.Get("ETag")
+
+	if response.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("expected a 200 with an ETag, got %d %q", response.StatusCode, etag)
+	}
+
+	if response := get(etag); response.StatusCode != http.StatusNotModified {
+		t.Errorf("expected a 304 for an unchanged bundle, got %d", response.StatusCode)
+	}
+
+	err = server.Replace(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response := get(etag); response.StatusCode != http.StatusOK || response.Header.Get("ETag") == etag {
+		t.Errorf("expected a 200 with a new ETag after Replace, got %d %q", response.StatusCode, response.Header.Get("ETag"))
+	}
+
+	if server.Requests.Load() != 3 || server.Downloads.Load() != 2 {
+		t.Errorf("unexpected counts: %d requests, %d downloads", server.Requests.Load(), server.Downloads.Load())
+	}
+}
+
+func TestWriteServiceConfig_KeepsSuiteConfig(t *testing.T) {
+
+	base := filepath.Join(t.TempDir(), "opa-config.yaml")
+	os.WriteFile(base, []byte("decision_logs:\n  console: true\nservices:\n  other:\n    url: http://example.com\n"), 0644)
+
+	filename, err := writeServiceConfig(base, "http://localhost:1234", BUNDLE_RESOURCE, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	data, _ := os.ReadFile(filename)
+
+	var document struct {
+		DecisionLogs map[string]interface{}            `yaml:"decision_logs"`
+		Services     map[string]map[string]interface{} `yaml:"services"`
+		Bundles      map[string]struct {
+			Service  string         `yaml:"service"`
+			Resource string         `yaml:"resource"`
+			Polling  map[string]int `yaml:"polling"`
+		} `yaml:"bundles"`
+	}
+
+	err = yaml.Unmarshal(data, &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if document.DecisionLogs["console"] != true || document.Services["other"] == nil {
+		t.Errorf("the suite's own config was lost: %s", string(data))
+	}
+
+	if document.Services[BUNDLE_SERVICE_NAME]["url"] != "http://localhost:1234" {
+		t.Errorf("missing bundle service: %s", string(data))
+	}
+
+	bundle := document.Bundles[BUNDLE_SERVICE_NAME]
+
+	if bundle.Service != BUNDLE_SERVICE_NAME || bundle.Resource != BUNDLE_RESOURCE || bundle.Polling["min_delay_seconds"] != 1 {
+		t.Errorf("unexpected bundle config: %s", string(data))
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  Partial evaluation, through the Compile API. OPA answers with the residual
+ *  queries (what's left once everything known has been evaluated), and any support
+ *  rules they need. Data-filtering integrations translate those residuals into
+ *  SQL and the like, so what they contain is worth testing.
+ *
+ *  A residual with no queries can never be true, one with an empty query is always
+ *  true, and anything else depends on the unknowns.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"raygun/log"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+const RESIDUAL_TRUE = "true"
+const RESIDUAL_FALSE = "false"
+const RESIDUAL_CONDITIONAL = "conditional"
+
+/*
+ *  An Evaluator that can also partially evaluate, like the /v1/compile API
+ */
+type CompileEvaluator interface {
+	Compile(query string, unknowns []string, body string) (string, error)
+}
+
+type CompileResult struct {
+	Queries []ast.Body    `json:"queries,omitempty"`
+	Support []*ast.Module `json:"support,omitempty"`
+}
+
+/*
+ *  Pull the residual out of a Compile API response
+ */
+func ParseCompileResult(response string) (*CompileResult, error) {
+
+	var wrapper struct {
+		Result *CompileResult `json:"result"`
+	}
+
+	err := json.Unmarshal([]byte(response), &wrapper)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the compile response: %w", err)
+	}
+
+	if wrapper.Result == nil {
+		return &CompileResult{}, nil
+	}
+
+	return wrapper.Result, nil
+}
+
+/*
+ *  true, false or conditional
+ */
+func (cr *CompileResult) Verdict() string {
+
+	if len(cr.Queries) == 0 {
+		return RESIDUAL_FALSE
+	}
+
+	for _, query := range cr.Queries {
+		if len(query) == 0 {
+			return RESIDUAL_TRUE
+		}
+	}
+
+	return RESIDUAL_CONDITIONAL
+}
+
+/*
+ *  Every input or data reference left in the residual, outside of the support
+ *  rules' own packages
+ */
+func (cr *CompileResult) References() []ast.Ref {
+
+	support := make([]ast.Ref, 0, len(cr.Support))
+	for _, module := range cr.Support {
+		support = append(support, module.Package.Path)
+	}
+
+	found := make(map[string]ast.Ref)
+
+	visit := func(ref ast.Ref) bool {
+
+		head, ok := ref[0].Value.(ast.Var)
+		if !ok || !(head.Equal(ast.InputRootDocument.Value) || head.Equal(ast.DefaultRootDocument.Value)) {
+			return false
+		}
+
+		for _, path := range support {
+			if ref.HasPrefix(path) {
+				return false
+			}
+		}
+
+		found[ref.String()] = ref
+
+		return false
+	}
+
+	for _, query := range cr.Queries {
+		ast.WalkRefs(query, visit)
+	}
+
+	for _, module := range cr.Support {
+		for _, rule := range module.Rules {
+			ast.WalkRefs(rule, visit)
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	references := make([]ast.Ref, len(keys))
+	for i, key := range keys {
+		references[i] = found[key]
+	}
+
+	return references
+}
+
+/*
+ *  The references that aren't under any of the allowed prefixes, like input.resource
+ */
+func (cr *CompileResult) ReferencesOutside(allowed []string) ([]string, error) {
+
+	prefixes := make([]ast.Ref, 0, len(allowed))
+
+	for _, text := range allowed {
+		prefix, err := ast.ParseRef(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid reference %s: %w", text, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	outside := make([]string, 0)
+
+	for _, ref := range cr.References() {
+
+		inside := false
+		for _, prefix := range prefixes {
+			if ref.HasPrefix(prefix) {
+				inside = true
+				break
+			}
+		}
+
+		if !inside {
+			outside = append(outside, ref.String())
+		}
+	}
+
+	return outside, nil
+}
+
+/*
+ *  The residual queries as Rego, one per line, for the report
+ */
+func (cr *CompileResult) String() string {
+
+	if len(cr.Queries) == 0 {
+		return "(no queries)"
+	}
+
+	lines := make([]string, len(cr.Queries))
+
+	for i, query := range cr.Queries {
+		if len(query) == 0 {
+			lines[i] = "(always true)"
+		} else {
+			lines[i] = query.String()
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+/*
+ *  Partial evaluation in-process, answering with the same document as /v1/compile.
+ *  Without unknowns, the whole input is unknown, as it is for OPA
+ */
+func (engine *EmbeddedEngine) Compile(query string, unknowns []string, body string) (string, error) {
+
+	options := []func(*rego.Rego){
+		rego.Query(query),
+		rego.ParsedBundle("raygun", engine.bundle),
+	}
+
+	if len(unknowns) > 0 {
+		options = append(options, rego.Unknowns(unknowns))
+	}
+
+	input, found, err := inputFromBody(body)
+	if err != nil {
+		return "", err
+	}
+
+	if found {
+		options = append(options, rego.Input(input))
+	}
+
+	// partial queries aren't prepared and cached, so the bundle is only compiled by one at a time
+	engine.lock.Lock()
+	partial, err := rego.New(options...).Partial(context.Background())
+	engine.lock.Unlock()
+
+	if err != nil {
+		return "", fmt.Errorf("partial evaluation of %s failed: %w", query, err)
+	}
+
+	response := map[string]interface{}{"result": CompileResult{Queries: partial.Queries, Support: partial.Support}}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug("Embedded compile response for %s: %s", query, string(b))
+
+	return string(b), nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const filterPolicy = `package filters
+
+allow if input.subject.admin
+
+allow if {
+	input.resource.owner == input.subject.name
+	not input.resource.archived
+}
+`
+
+func TestCompile_Residuals(t *testing.T) {
+
+	directory := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(directory, "filters.rego"), []byte(filterPolicy), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := NewEmbeddedEngine(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body    string
+		verdict string
+	}{
+		{`{"input": {"subject": {"name": "ray", "admin": true}}}`, RESIDUAL_TRUE},
+		{`{"input": {"subject": {"name": "ray", "admin": false}}}`, RESIDUAL_CONDITIONAL},
+	}
+
+	for _, test := range tests {
+
+		response, err := engine.Compile("data.filters.allow == true", []string{"input.resource"}, test.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		residual, err := ParseCompileResult(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if residual.Verdict() != test.verdict {
+			t.Errorf("%s: got %s, want %s\n%s", test.body, residual.Verdict(), test.verdict, residual.String())
+		}
+
+		outside, err := residual.ReferencesOutside([]string{"input.resource"})
+		if err != nil || len(outside) > 0 {
+			t.Errorf("%s: unexpected references %v %v", test.body, outside, err)
+		}
+
+		if test.verdict == RESIDUAL_CONDITIONAL {
+
+			// the residual needs support rules for the negation, which still only use input.resource
+			if !strings.Contains(residual.String(), `"ray" = input.resource.owner`) {
+				t.Errorf("unexpected residual: %s", residual.String())
+			}
+
+			outside, _ := residual.ReferencesOutside([]string{"input.resource.owner"})
+			if len(outside) != 1 || outside[0] != "input.resource.archived" {
+				t.Errorf("expected only input.resource.archived outside, got %v", outside)
+			}
+		}
+	}
+
+	// no queries at all can never be true
+	residual, err := ParseCompileResult(`{"result": {}}`)
+	if err != nil || residual.Verdict() != RESIDUAL_FALSE {
+		t.Errorf("expected an empty result to be false: %v", err)
+	}
+}
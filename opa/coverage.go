@@ -0,0 +1,293 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  Coverage: every request a suite sends is evaluated a second time, in-process,
+ *  against the same bundle, with OPA's coverage tracer. The suite stays black-box,
+ *  but the policy owners get to see which lines and rules none of its inputs reach.
+ *
+ *  Like opa test --coverage, a line is covered once one of its expressions has been
+ *  evaluated, and a rule's head once the rule produced a value.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/cover"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+type CoverageEngine struct {
+	engine *EmbeddedEngine
+	tracer *cover.Cover
+	lock   sync.Mutex // the tracer isn't safe for concurrent evaluations
+}
+
+type CoverageReport struct {
+	Files           []FileCoverage `json:"files"`
+	CoveredLines    int            `json:"covered_lines"`
+	NotCoveredLines int            `json:"not_covered_lines"`
+	Coverage        float64        `json:"coverage"`
+}
+
+type FileCoverage struct {
+	File            string         `json:"file"`
+	CoveredLines    int            `json:"covered_lines"`
+	NotCoveredLines int            `json:"not_covered_lines"`
+	Coverage        float64        `json:"coverage"`
+	Covered         []int          `json:"covered,omitempty"`
+	NotCovered      []int          `json:"not_covered,omitempty"`
+	Rules           []RuleCoverage `json:"rules"`
+}
+
+type RuleCoverage struct {
+	Name            string  `json:"name"`
+	Line            int     `json:"line"`
+	CoveredLines    int     `json:"covered_lines"`
+	NotCoveredLines int     `json:"not_covered_lines"`
+	Coverage        float64 `json:"coverage"`
+}
+
+func NewCoverageEngine(bundle_path string, signing *SigningConfig) (*CoverageEngine, error) {
+
+	engine, err := NewEmbeddedEngine(bundle_path, signing)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CoverageEngine{engine: engine, tracer: cover.New()}, nil
+}
+
+func (coverage *CoverageEngine) Evaluate(decision_path string, body string) (string, error) {
+
+	coverage.lock.Lock()
+	defer coverage.lock.Unlock()
+
+	return coverage.engine.evaluate(decision_path, body, rego.EvalQueryTracer(coverage.tracer))
+}
+
+func (coverage *CoverageEngine) Query(query string, body string) (string, error) {
+
+	coverage.lock.Lock()
+	defer coverage.lock.Unlock()
+
+	return coverage.engine.query(query, body, rego.EvalQueryTracer(coverage.tracer))
+}
+
+/*
+ *  The coverage so far, for every policy file in the bundle, in file order
+ */
+func (coverage *CoverageEngine) Report() *CoverageReport {
+
+	coverage.lock.Lock()
+	defer coverage.lock.Unlock()
+
+	modules := make(map[string]*ast.Module)
+	for _, module := range coverage.engine.bundle.Modules {
+		modules[module.Parsed.Package.Location.File] = module.Parsed
+	}
+
+	traced := coverage.tracer.Report(modules)
+
+	report := &CoverageReport{}
+
+	for file, module := range modules {
+
+		file_report, found := traced.Files[file]
+		if !found || file_report.CoveredLines+file_report.NotCoveredLines == 0 {
+			// nothing in it that can be evaluated, only a package and imports
+			continue
+		}
+
+		file_coverage := FileCoverage{
+			File:            file,
+			CoveredLines:    file_report.CoveredLines,
+			NotCoveredLines: file_report.NotCoveredLines,
+			Coverage:        file_report.Coverage,
+			Covered:         rangeLines(file_report.Covered),
+			NotCovered:      rangeLines(file_report.NotCovered),
+			Rules:           ruleCoverage(module, file_report),
+		}
+
+		report.Files = append(report.Files, file_coverage)
+		report.CoveredLines += file_coverage.CoveredLines
+		report.NotCoveredLines += file_coverage.NotCoveredLines
+	}
+
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].File < report.Files[j].File
+	})
+
+	report.Coverage = percentage(report.CoveredLines, report.NotCoveredLines)
+
+	return report
+}
+
+/*
+ *  Each rule (and else) by the lines of its head and body
+ */
+func ruleCoverage(module *ast.Module, file_report *cover.FileReport) []RuleCoverage {
+
+	rules := make([]RuleCoverage, 0)
+
+	ast.WalkRules(module, func(rule *ast.Rule) bool {
+
+		lines := map[int]bool{rule.Head.Location.Row: true}
+
+		ast.WalkExprs(rule.Body, func(expr *ast.Expr) bool {
+			if expr.Location != nil {
+				lines[expr.Location.Row] = true
+			}
+			return false
+		})
+
+		rule_coverage := RuleCoverage{Name: rule.Head.Ref().String(), Line: rule.Head.Location.Row}
+
+		for line := range lines {
+			if file_report.IsCovered(line) {
+				rule_coverage.CoveredLines++
+			} else if file_report.IsNotCovered(line) {
+				rule_coverage.NotCoveredLines++
+			}
+		}
+
+		rule_coverage.Coverage = percentage(rule_coverage.CoveredLines, rule_coverage.NotCoveredLines)
+
+		rules = append(rules, rule_coverage)
+
+		return false
+	})
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Line < rules[j].Line
+	})
+
+	return rules
+}
+
+func rangeLines(ranges []cover.Range) []int {
+
+	lines := make([]int, 0)
+
+	for _, r := range ranges {
+		for line := r.Start.Row; line <= r.End.Row; line++ {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func percentage(covered int, not_covered int) float64 {
+
+	if covered+not_covered == 0 {
+		return 0
+	}
+
+	return 100.0 * float64(covered) / float64(covered+not_covered)
+}
+
+/*
+ *  3-5, 9, 12-13
+ */
+func LineRanges(lines []int) string {
+
+	parts := make([]string, 0)
+
+	for i := 0; i < len(lines); i++ {
+
+		start := lines[i]
+		for i+1 < len(lines) && lines[i+1] == lines[i]+1 {
+			i++
+		}
+
+		if lines[i] == start {
+			parts = append(parts, fmt.Sprintf("%d", start))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", start, lines[i]))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+/*
+ *  The report as an lcov tracefile record per policy file, under the test name
+ *  (the suite). A rule counts as hit when any of its lines were covered
+ */
+func (report *CoverageReport) Lcov(test_name string) string {
+
+	var sb strings.Builder
+
+	for _, file := range report.Files {
+
+		sb.WriteString(fmt.Sprintf("TN:%s\n", lcovName(test_name)))
+		sb.WriteString(fmt.Sprintf("SF:%s\n", file.File))
+
+		// a rule defined more than once needs its line, lcov function names are unique
+		definitions := make(map[string]int)
+		for _, rule := range file.Rules {
+			definitions[rule.Name]++
+		}
+
+		names := make([]string, len(file.Rules))
+		for i, rule := range file.Rules {
+			names[i] = rule.Name
+			if definitions[rule.Name] > 1 {
+				names[i] = fmt.Sprintf("%s:%d", rule.Name, rule.Line)
+			}
+			sb.WriteString(fmt.Sprintf("FN:%d,%s\n", rule.Line, names[i]))
+		}
+
+		hit := 0
+		for i, rule := range file.Rules {
+			count := 0
+			if rule.CoveredLines > 0 {
+				count = 1
+				hit++
+			}
+			sb.WriteString(fmt.Sprintf("FNDA:%d,%s\n", count, names[i]))
+		}
+		sb.WriteString(fmt.Sprintf("FNF:%d\nFNH:%d\n", len(file.Rules), hit))
+
+		lines := make(map[int]int)
+		for _, line := range file.Covered {
+			lines[line] = 1
+		}
+		for _, line := range file.NotCovered {
+			lines[line] = 0
+		}
+
+		rows := make([]int, 0, len(lines))
+		for line := range lines {
+			rows = append(rows, line)
+		}
+		sort.Ints(rows)
+
+		for _, line := range rows {
+			sb.WriteString(fmt.Sprintf("DA:%d,%d\n", line, lines[line]))
+		}
+
+		sb.WriteString(fmt.Sprintf("LF:%d\nLH:%d\n", len(rows), len(file.Covered)))
+		sb.WriteString("end_of_record\n")
+	}
+
+	return sb.String()
+}
+
+// lcov test names are letters, digits and underscores
+func lcovName(name string) string {
+
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const coveragePolicy = `package app
+
+default allow := false
+
+allow if {
+	input.user == "ray"
+	input.action == "read"
+}
+
+admin if {
+	input.user == "root"
+}
+`
+
+func TestCoverage_Report(t *testing.T) {
+
+	directory := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(directory, "app.rego"), []byte(coveragePolicy), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coverage, err := NewCoverageEngine(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := coverage.Evaluate("/v1/data/app/allow", `{"input": {"user": "ray", "action": "read"}}`)
+	if err != nil || response != `{"result":true}` {
+		t.Fatalf("unexpected response %s %v", response, err)
+	}
+
+	report := coverage.Report()
+
+	if len(report.Files) != 1 || !strings.HasSuffix(report.Files[0].File, "app.rego") {
+		t.Fatalf("expected app.rego in the report: %+v", report.Files)
+	}
+
+	file := report.Files[0]
+
+	if LineRanges(file.NotCovered) != "3, 10-11" {
+		t.Errorf("expected the default and the admin rule to be all that is not covered, got %s", LineRanges(file.NotCovered))
+	}
+
+	expected_rules := []RuleCoverage{
+		{Name: "allow", Line: 3, CoveredLines: 0, NotCoveredLines: 1, Coverage: 0},
+		{Name: "allow", Line: 5, CoveredLines: 3, NotCoveredLines: 0, Coverage: 100},
+		{Name: "admin", Line: 10, CoveredLines: 0, NotCoveredLines: 2, Coverage: 0},
+	}
+
+	if len(file.Rules) != len(expected_rules) {
+		t.Fatalf("unexpected rule coverage %+v", file.Rules)
+	}
+
+	for i, rule := range file.Rules {
+		if rule != expected_rules[i] {
+			t.Errorf("got %+v, want %+v", rule, expected_rules[i])
+		}
+	}
+
+	lcov := report.Lcov("my suite")
+	for _, expected := range []string{"TN:my_suite\n", "FNDA:0,admin\n", "FNDA:1,allow:5\n", "DA:11,0\n", "DA:6,1\n", "end_of_record\n"} {
+		if !strings.Contains(lcov, expected) {
+			t.Errorf("expected %q in the lcov report:\n%s", expected, lcov)
+		}
+	}
+}
+
+func TestLineRanges(t *testing.T) {
+
+	if ranges := LineRanges([]int{3, 4, 5, 9, 12, 13}); ranges != "3-5, 9, 12-13" {
+		t.Errorf("unexpected ranges %s", ranges)
+	}
+}
@@ -0,0 +1,314 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  The embedded engine evaluates decisions in-process with OPA's rego package,
+ *  instead of starting an OPA server and talking to it over HTTP.
+ *
+ *  It answers with the same {"result": ...} document the server would, so the
+ *  rest of raygun can't tell the difference.
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"raygun/log"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/loader"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+const ENGINE_HTTP = "http"
+const ENGINE_EMBEDDED = "embedded"
+const ENGINE_WASM = "wasm"
+
+/*
+ *  Anything that can answer a request for a decision without going through
+ *  an OPA server
+ */
+type Evaluator interface {
+	Evaluate(decision_path string, body string) (string, error)
+}
+
+/*
+ *  An Evaluator that can also run ad-hoc queries, like the /v1/query API
+ */
+type QueryEvaluator interface {
+	Query(query string, body string) (string, error)
+}
+
+type EmbeddedEngine struct {
+	BundlePath string
+	bundle     *bundle.Bundle
+	prepared   map[string]rego.PreparedEvalQuery
+	lock       sync.Mutex
+}
+
+/*
+ *  Load the bundle (a tar.gz, or a directory) once, up front, so a broken bundle
+ *  fails the suite the same way it would fail OPA startup
+ */
+func NewEmbeddedEngine(bundle_path string, signing *SigningConfig) (*EmbeddedEngine, error) {
+
+	log.Debug("Loading bundle %s for the embedded engine", bundle_path)
+
+	b, err := loadBundle(bundle_path, signing)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &EmbeddedEngine{
+		BundlePath: bundle_path,
+		bundle:     b,
+		prepared:   make(map[string]rego.PreparedEvalQuery),
+	}
+
+	return engine, nil
+}
+
+/*
+ *  Load a bundle for one of the in-process engines. With a signing config, the
+ *  bundle's signatures are verified as it's loaded, just as OPA would
+ */
+func loadBundle(bundle_path string, signing *SigningConfig) (*bundle.Bundle, error) {
+
+	file_loader := loader.NewFileLoader().WithRegoVersion(ast.RegoV1)
+
+	if signing != nil {
+		verification, err := signing.VerificationConfig()
+		if err != nil {
+			return nil, err
+		}
+		file_loader = file_loader.WithBundleVerificationConfig(verification)
+	} else {
+		// like opa run -b without a verification key, a signed bundle loads as it is
+		file_loader = file_loader.WithSkipBundleVerification(true)
+	}
+
+	b, err := file_loader.AsBundle(bundle_path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load bundle %s: %w", bundle_path, err)
+	}
+
+	return b, nil
+}
+
+func (engine *EmbeddedEngine) Evaluate(decision_path string, body string) (string, error) {
+	return engine.evaluate(decision_path, body)
+}
+
+/*
+ *  Evaluate, with extra options (like a tracer) for the evaluation
+ */
+func (engine *EmbeddedEngine) evaluate(decision_path string, body string, options ...rego.EvalOption) (string, error) {
+
+	query, err := engine.prepare(decision_path)
+	if err != nil {
+		return "", err
+	}
+
+	input, found, err := inputFromBody(body)
+	if err != nil {
+		return "", err
+	}
+
+	if found {
+		options = append(options, rego.EvalInput(input))
+	}
+
+	result_set, err := query.Eval(context.Background(), options...)
+	if err != nil {
+		return "", fmt.Errorf("evaluation of %s failed: %w", decision_path, err)
+	}
+
+	// an undefined decision is an empty document, just like the server
+	response := make(map[string]interface{})
+
+	if len(result_set) > 0 && len(result_set[0].Expressions) > 0 {
+		response["result"] = result_set[0].Expressions[0].Value
+	}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug("Embedded response for %s: %s", decision_path, string(b))
+
+	return string(b), nil
+}
+
+/*
+ *  An ad-hoc query answers like /v1/query does: the variable bindings of every
+ *  result, without the wildcards, and no result at all when it's undefined
+ */
+func (engine *EmbeddedEngine) Query(query string, body string) (string, error) {
+	return engine.query(query, body)
+}
+
+func (engine *EmbeddedEngine) query(query string, body string, options ...rego.EvalOption) (string, error) {
+
+	prepared, err := engine.prepareQuery(query)
+	if err != nil {
+		return "", err
+	}
+
+	input, found, err := inputFromBody(body)
+	if err != nil {
+		return "", err
+	}
+
+	if found {
+		options = append(options, rego.EvalInput(input))
+	}
+
+	result_set, err := prepared.Eval(context.Background(), options...)
+	if err != nil {
+		return "", fmt.Errorf("evaluation of query %s failed: %w", query, err)
+	}
+
+	response := make(map[string]interface{})
+
+	if len(result_set) > 0 {
+		bindings := make([]map[string]interface{}, len(result_set))
+		for i, result := range result_set {
+			bindings[i] = result.Bindings.WithoutWildcards()
+		}
+		response["result"] = bindings
+	}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug("Embedded response for query %s: %s", query, string(b))
+
+	return string(b), nil
+}
+
+/*
+ *  Each decision path is compiled once, and then shared by every test that uses it
+ */
+func (engine *EmbeddedEngine) prepare(decision_path string) (rego.PreparedEvalQuery, error) {
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	if query, found := engine.prepared[decision_path]; found {
+		return query, nil
+	}
+
+	ref, err := DecisionRef(decision_path)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+
+	query, err := rego.New(
+		rego.Query(ref.String()),
+		rego.ParsedBundle("raygun", engine.bundle),
+	).PrepareForEval(context.Background())
+
+	if err != nil {
+		return query, fmt.Errorf("unable to prepare query for %s: %w", decision_path, err)
+	}
+
+	engine.prepared[decision_path] = query
+
+	return query, nil
+}
+
+/*
+ *  Ad-hoc queries are cached alongside the decisions, so a query shared by several
+ *  tests is only compiled once
+ */
+func (engine *EmbeddedEngine) prepareQuery(query_text string) (rego.PreparedEvalQuery, error) {
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	key := "query:" + query_text
+
+	if query, found := engine.prepared[key]; found {
+		return query, nil
+	}
+
+	query, err := rego.New(
+		rego.Query(query_text),
+		rego.ParsedBundle("raygun", engine.bundle),
+	).PrepareForEval(context.Background())
+
+	if err != nil {
+		return query, fmt.Errorf("unable to prepare query %s: %w", query_text, err)
+	}
+
+	engine.prepared[key] = query
+
+	return query, nil
+}
+
+/*
+ *  /v1/data/a/b -> data.a.b
+ */
+func DecisionRef(decision_path string) (ast.Ref, error) {
+
+	path := strings.Trim(decision_path, "/")
+
+	for _, prefix := range []string{"v1/data", "v0/data"} {
+		if path == prefix {
+			path = ""
+			break
+		}
+		if strings.HasPrefix(path, prefix+"/") {
+			path = strings.TrimPrefix(path, prefix+"/")
+			break
+		}
+	}
+
+	if strings.HasPrefix(path, "v1/") || strings.HasPrefix(path, "v0/") {
+		return nil, fmt.Errorf("unsupported decision path for an in-process engine: %s", decision_path)
+	}
+
+	ref := ast.Ref{ast.DefaultRootDocument}
+
+	if path != "" {
+		for _, segment := range strings.Split(path, "/") {
+			ref = append(ref, ast.StringTerm(segment))
+		}
+	}
+
+	return ref, nil
+}
+
+/*
+ *  The request body is {"input": ...}. Numbers are kept as json.Number, so large
+ *  integers survive the trip into the evaluator intact
+ */
+func inputFromBody(body string) (interface{}, bool, error) {
+
+	if strings.TrimSpace(body) == "" {
+		return nil, false, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(body)))
+	decoder.UseNumber()
+
+	var document map[string]interface{}
+
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, false, fmt.Errorf("request body is not a JSON object: %w", err)
+	}
+
+	input, found := document["input"]
+
+	return input, found, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const embeddedPolicy = `package app
+
+allow if input.user == "ray"
+
+echo := input.id
+
+limits := {"max": 10}
+`
+
+func embeddedEngine(t *testing.T) *EmbeddedEngine {
+
+	directory := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(directory, "app.rego"), []byte(embeddedPolicy), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := NewEmbeddedEngine(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return engine
+}
+
+func TestEmbeddedEngine_Evaluate(t *testing.T) {
+
+	engine := embeddedEngine(t)
+
+	tests := []struct {
+		decision_path string
+		body          string
+		response      string
+	}{
+		{"/v1/data/app/allow", `{"input": {"user": "ray"}}`, `{"result":true}`},
+		// undefined is an empty document, like the server
+		{"/v1/data/app/allow", `{"input": {"user": "bob"}}`, `{}`},
+		{"/v1/data/app/allow", ``, `{}`},
+		// large integers come back intact
+		{"/v1/data/app/echo", `{"input": {"id": 12345678901234567890}}`, `{"result":12345678901234567890}`},
+		{"/v0/data/app/limits/max", ``, `{"result":10}`},
+	}
+
+	for _, test := range tests {
+
+		response, err := engine.Evaluate(test.decision_path, test.body)
+		if err != nil {
+			t.Fatalf("%s: %v", test.decision_path, err)
+		}
+
+		if response != test.response {
+			t.Errorf("%s %s: got %s, want %s", test.decision_path, test.body, response, test.response)
+		}
+	}
+}
+
+func TestEmbeddedEngine_Errors(t *testing.T) {
+
+	engine := embeddedEngine(t)
+
+	if _, err := engine.Evaluate("/v1/query", ``); err == nil {
+		t.Errorf("expected an error for a path that isn't a decision")
+	}
+
+	if _, err := engine.Evaluate("/v1/data/app/allow", `not json`); err == nil {
+		t.Errorf("expected an error for a body that isn't JSON")
+	}
+
+	if _, err := NewEmbeddedEngine(filepath.Join(t.TempDir(), "missing.tar.gz"), nil); err == nil {
+		t.Errorf("expected an error for a missing bundle")
+	}
+}
+
+func TestEmbeddedEngine_Query(t *testing.T) {
+
+	engine := embeddedEngine(t)
+
+	response, err := engine.Query(`x := data.app.limits.max`, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response != `{"result":[{"x":10}]}` {
+		t.Errorf("unexpected query response %s", response)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  Explanations: the evaluation trace of a decision, the way OPA returns it for
+ *  ?explain=...&pretty=true. notes keeps only the trace() notes, fails only the
+ *  expressions that failed, and full is everything.
+ */
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/topdown"
+	"github.com/open-policy-agent/opa/v1/topdown/lineage"
+)
+
+const EXPLAIN_OFF = "off"
+const EXPLAIN_NOTES = "notes"
+const EXPLAIN_FAILS = "fails"
+const EXPLAIN_FULL = "full"
+
+var EXPLAIN_MODES = []string{EXPLAIN_OFF, EXPLAIN_NOTES, EXPLAIN_FAILS, EXPLAIN_FULL}
+
+/*
+ *  An Evaluator that can also explain a decision
+ */
+type ExplainEvaluator interface {
+	Explain(decision_path string, body string, mode string) (string, error)
+}
+
+func ValidExplainMode(mode string) bool {
+
+	for _, valid := range EXPLAIN_MODES {
+		if mode == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ *  Evaluate the decision again, with a tracer, and pretty print the trace the
+ *  same way the server does
+ */
+func (engine *EmbeddedEngine) Explain(decision_path string, body string, mode string) (string, error) {
+
+	query, err := engine.prepare(decision_path)
+	if err != nil {
+		return "", err
+	}
+
+	tracer := topdown.NewBufferTracer()
+
+	options := []rego.EvalOption{rego.EvalQueryTracer(tracer)}
+
+	input, found, err := inputFromBody(body)
+	if err != nil {
+		return "", err
+	}
+
+	if found {
+		options = append(options, rego.EvalInput(input))
+	}
+
+	_, err = query.Eval(context.Background(), options...)
+	if err != nil {
+		return "", fmt.Errorf("evaluation of %s failed: %w", decision_path, err)
+	}
+
+	events := *tracer
+
+	switch mode {
+	case EXPLAIN_NOTES:
+		events = lineage.Notes(events)
+	case EXPLAIN_FAILS:
+		events = lineage.Fails(events)
+	default:
+		events = lineage.Full(events)
+	}
+
+	var buffer bytes.Buffer
+
+	topdown.PrettyTraceWithLocation(&buffer, events)
+
+	return strings.TrimRight(buffer.String(), "\n"), nil
+}
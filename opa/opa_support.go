@@ -4,12 +4,21 @@ Copyright © 2024 PACLabs
 package opa
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"raygun/config"
 	"raygun/log"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -17,13 +26,22 @@ import (
  *  The configuration we need to start OPA
  */
 type OpaConfig struct {
-	OpaPort     uint16 `yaml:"port,omitempty"`
-	OpaPath     string `yaml:"path,omitempty"`
-	BundlePath  string `yaml:"bundle-path"`
-	LogPath     string `yaml:"log-path"`
-	ConfigFile  string `yaml:"config-file,omitempty"`
-	BundleUrl   string `yaml:"bundle-url"`
-	EndpointUrl string `yaml:"endpoint-url"`
+	OpaPort         uint16         `yaml:"port,omitempty"`
+	OpaPath         string         `yaml:"path,omitempty"`
+	BundlePath      string         `yaml:"bundle-path"`
+	LogPath         string         `yaml:"log-path"`
+	ConfigFile      string         `yaml:"config-file,omitempty"`
+	BundleUrl       string         `yaml:"bundle-url"`
+	EndpointUrl     string         `yaml:"endpoint-url"`
+	StartupTimeout  time.Duration  `yaml:"startup-timeout,omitempty"`
+	Engine          string         `yaml:"engine,omitempty"`     // http (an OPA process), embedded or wasm
+	SourceDir       string         `yaml:"source-dir,omitempty"` // build the bundle from this directory
+	Roots           []string       `yaml:"roots,omitempty"`
+	Revision        string         `yaml:"revision,omitempty"`
+	BundleServer    bool           `yaml:"bundle-server,omitempty"`    // serve the bundle to OPA over HTTP, instead of -b
+	PollingInterval time.Duration  `yaml:"polling-interval,omitempty"` // how often OPA polls for a new bundle
+	Signing         *SigningConfig `yaml:"signing,omitempty"`          // verify the bundle's signatures
+	DefaultBundle   bool           `yaml:"-"`                          // no bundle-path (or source-dir) in the suite, so BundlePath is only the -b default
 }
 
 func (oc OpaConfig) GetAgentUrl() string {
@@ -35,16 +53,56 @@ func (oc OpaConfig) GetAgentUrl() string {
 }
 
 func (oc OpaConfig) String() string {
-	return fmt.Sprintf("exec: %s, config: %s, bundle: %s, logs: %s", oc.OpaPath, oc.ConfigFile, oc.BundlePath, oc.LogPath)
+	return fmt.Sprintf("engine: %s, exec: %s, config: %s, bundle: %s, logs: %s", oc.Engine, oc.OpaPath, oc.ConfigFile, oc.BundlePath, oc.LogPath)
+}
+
+/*
+ *  true if decisions are evaluated in-process, rather than by an OPA we start
+ */
+func (oc OpaConfig) InProcess() bool {
+	return oc.Engine == ENGINE_EMBEDDED || oc.Engine == ENGINE_WASM
 }
 
 /*
  * Details about an OPA process that is about to start, or has started
  */
 type OpaRunner struct {
-	Config  OpaConfig
-	Remote  bool
-	Process *os.Process
+	Config    OpaConfig
+	Remote    bool
+	Evaluator Evaluator // set when decisions are evaluated in-process
+	Process   *os.Process
+	State     *os.ProcessState // set once the process has exited
+	exited    chan struct{}    // closed once the process has exited
+
+	BundleServer   *BundleServer // set when OPA loads its bundle from us
+	generatedFiles []string      // config and key files we wrote, and need to clean up
+}
+
+// how often we ask OPA whether it's ready yet
+const READINESS_POLL_INTERVAL = 100 * time.Millisecond
+
+// how much of the OPA log we show when it fails to start
+const LOG_TAIL_LINES = 20
+
+/*
+ *  Every OPA process we've started and not yet stopped, so they can all be
+ *  stopped on the way out, no matter how we're leaving
+ */
+var running = make(map[*OpaRunner]struct{})
+var runningLock sync.Mutex
+
+func StopAll() {
+
+	runningLock.Lock()
+	runners := make([]*OpaRunner, 0, len(running))
+	for runner := range running {
+		runners = append(runners, runner)
+	}
+	runningLock.Unlock()
+
+	for _, runner := range runners {
+		runner.Stop()
+	}
 }
 
 func NewOpaRunner(config OpaConfig) OpaRunner {
@@ -64,6 +122,29 @@ func (opa *OpaRunner) Start() error {
 		return nil
 	}
 
+	switch opa.Config.Engine {
+	case "", ENGINE_HTTP:
+		// the usual case, start OPA below
+	case ENGINE_EMBEDDED:
+		log.Debug("Using the embedded engine, no need to start OPA")
+		engine, err := NewEmbeddedEngine(opa.Config.BundlePath, opa.Config.Signing)
+		if err != nil {
+			return err
+		}
+		opa.Evaluator = engine
+		return nil
+	case ENGINE_WASM:
+		log.Debug("Using the wasm engine, no need to start OPA")
+		engine, err := NewWasmEngine(opa.Config.BundlePath, opa.Config.Signing)
+		if err != nil {
+			return err
+		}
+		opa.Evaluator = engine
+		return nil
+	default:
+		return fmt.Errorf("unsupported engine: %s (expecting %s, %s or %s)", opa.Config.Engine, ENGINE_HTTP, ENGINE_EMBEDDED, ENGINE_WASM)
+	}
+
 	commandToRun := opa.Config.OpaPath
 
 	absolute_path, err := exec.LookPath(commandToRun)
@@ -86,44 +167,306 @@ func (opa *OpaRunner) Start() error {
 
 	log.Debug("OpaRunner.Start() - commandToRun: %s - absolute_path: %s", commandToRun, absolute_path)
 
-	var args []string
+	// if something is already listening on our port, every test would go to it
+	// instead of the OPA we're about to start
+	if portInUse(opa.Config.OpaPort) {
+		log.Error("Port %d is already in use. Is an OPA from a previous run still running?", opa.Config.OpaPort)
+		log.Error("Stop that process, or choose a different port with --opa-port")
+		return fmt.Errorf("port %d is already in use by another process", opa.Config.OpaPort)
+	}
 
-	if opa.Config.ConfigFile != "" {
-		args = []string{commandToRun, "run", "--server", "-b", opa.Config.BundlePath, "--config-file", opa.Config.ConfigFile}
-	} else {
-		args = []string{commandToRun, "run", "--server", "-b", opa.Config.BundlePath}
+	// OPA listens on the port we'll be sending requests to
+	address := fmt.Sprintf("localhost:%d", opa.Config.OpaPort)
+
+	args := []string{commandToRun, "run", "--server", "--addr", address}
+
+	bundle_args, err := opa.bundleArguments()
+	if err != nil {
+		opa.cleanup()
+		return err
 	}
 
+	args = append(args, bundle_args...)
+
 	log.Debug("OpaRunner.Start() - arg string: %v", args)
 
 	opaLog, err := os.Create(opa.Config.LogPath)
 
 	if err != nil {
 		log.Error("Unable to create file: %s : %s", opa.Config.LogPath, err.Error())
+		opa.cleanup()
 		return err
 	}
 
 	process_attributes := new(os.ProcAttr)
-	process_attributes.Files = []*os.File{os.Stdin, os.Stdout, opaLog}
+	// OPA reports fatal startup errors (like a bundle that fails verification) on
+	// stdout, so that goes to the log too, where the startup failure report finds it
+	process_attributes.Files = []*os.File{os.Stdin, opaLog, opaLog}
 
 	process, err := os.StartProcess(absolute_path, args, process_attributes)
 
 	if err != nil {
 		log.Error("Unable to start OPA: %s", err.Error())
+		opa.cleanup()
 		return err
 	}
 
 	log.Debug("Started OPA via executable: %s . Process id: %d", commandToRun, process.Pid)
 
 	opa.Process = process
+	opa.exited = make(chan struct{})
+
+	runningLock.Lock()
+	running[opa] = struct{}{}
+	runningLock.Unlock()
+
+	// reap the process as soon as it exits, so we notice if it dies during startup
+	go func() {
+		state, err := process.Wait()
+		if err != nil {
+			log.Debug("OpaRunner: wait for process %d failed: %s", process.Pid, err.Error())
+		}
+		opa.State = state
+		close(opa.exited)
+	}()
+
+	return opa.waitUntilReady()
+}
+
+/*
+ *  How OPA gets its bundle:
+ *
+ *    bundle-server: true      - from our bundle server, via a generated config file
+ *    bundle-url: https://...  - from a remote server, via a generated config file
+ *    otherwise                - from the bundle file, with -b
+ *
+ *  The generated config file includes the suite's config-file, if there is one.
+ *  With a signing: section, OPA verifies the bundle's signatures, either with the
+ *  --verification-key flags (for -b) or with keys: in the generated config
+ */
+func (opa *OpaRunner) bundleArguments() ([]string, error) {
+
+	polling_seconds := int(opa.Config.PollingInterval / time.Second)
+
+	if opa.Config.BundleServer {
+
+		server, err := NewBundleServer(opa.Config.BundlePath)
+		if err != nil {
+			return nil, err
+		}
+
+		opa.BundleServer = server
+
+		return opa.serviceArguments(server.Url(), BUNDLE_RESOURCE, polling_seconds)
+	}
+
+	if strings.HasPrefix(opa.Config.BundleUrl, "http://") || strings.HasPrefix(opa.Config.BundleUrl, "https://") {
+
+		bundle_url, err := url.Parse(opa.Config.BundleUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle url %s: %w", opa.Config.BundleUrl, err)
+		}
+
+		service_url := bundle_url.Scheme + "://" + bundle_url.Host
+		resource := strings.TrimPrefix(bundle_url.RequestURI(), "/")
+
+		return opa.serviceArguments(service_url, resource, polling_seconds)
+	}
+
+	args := []string{"-b", opa.Config.BundlePath}
+
+	if opa.Config.ConfigFile != "" {
+		args = append(args, "--config-file", opa.Config.ConfigFile)
+	}
+
+	if opa.Config.Signing != nil {
+
+		verification_args, key_file, err := opa.Config.Signing.verificationArguments()
+		if err != nil {
+			return nil, err
+		}
+
+		if key_file != "" {
+			opa.generatedFiles = append(opa.generatedFiles, key_file)
+		}
+
+		args = append(args, verification_args...)
+	}
+
+	return args, nil
+}
+
+func (opa *OpaRunner) serviceArguments(service_url string, resource string, polling_seconds int) ([]string, error) {
+
+	config_file, err := writeServiceConfig(opa.Config.ConfigFile, service_url, resource, polling_seconds, opa.Config.Signing)
+	if err != nil {
+		return nil, err
+	}
+
+	opa.generatedFiles = append(opa.generatedFiles, config_file)
+
+	return []string{"--config-file", config_file}, nil
+}
+
+/*
+ *  Stop the bundle server and remove the files we generated, if any
+ */
+func (opa *OpaRunner) cleanup() {
+
+	if opa.BundleServer != nil {
+		opa.BundleServer.Close()
+		opa.BundleServer = nil
+	}
+
+	for _, filename := range opa.generatedFiles {
+		os.Remove(filename)
+	}
+
+	opa.generatedFiles = nil
+}
+
+/*
+ *  Poll /health?bundles until OPA reports that it's up and its bundles have been
+ *  activated, then check /v1/status for bundle errors. If OPA exits, doesn't become
+ *  healthy in time, or reports a bundle error, we fail with the tail of the OPA log,
+ *  since that's almost always where the explanation is
+ */
+func (opa *OpaRunner) waitUntilReady() error {
+
+	timeout := opa.Config.StartupTimeout
+	if timeout <= 0 {
+		timeout = config.DEFAULT_OPA_STARTUP_TIMEOUT
+	}
+
+	health_url := opa.Config.GetAgentUrl() + "/health?bundles"
+	client := http.Client{Timeout: time.Second}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+
+	log.Debug("Waiting up to %v for OPA to report healthy at %s", timeout, health_url)
+
+	for {
+		select {
+		case <-opa.exited:
+			return fmt.Errorf("OPA exited during startup (%v). OPA log %s:\n%s", opa.State, opa.Config.LogPath, tailFile(opa.Config.LogPath, LOG_TAIL_LINES))
+		default:
+		}
+
+		response, err := client.Get(health_url)
+		if err == nil {
+			response.Body.Close()
+
+			if response.StatusCode == http.StatusOK {
+				log.Debug("OPA is healthy after %v", time.Since(start))
+
+				err := opa.checkStatus(client)
+				if err != nil {
+					return fmt.Errorf("%w. OPA log %s:\n%s", err, opa.Config.LogPath, tailFile(opa.Config.LogPath, LOG_TAIL_LINES))
+				}
+
+				return nil
+			}
 
-	// I don't know that we have to wait a full second for this.  TBD
-	log.Debug("Waiting for 1 second for OPA to start up")
-	time.Sleep(1 * time.Second)
+			log.Debug("OPA health check returned %d, still waiting", response.StatusCode)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("OPA did not become healthy within %v. OPA log %s:\n%s", timeout, opa.Config.LogPath, tailFile(opa.Config.LogPath, LOG_TAIL_LINES))
+		}
+
+		time.Sleep(READINESS_POLL_INTERVAL)
+	}
+}
+
+/*
+ *  /v1/status only answers when the status plugin is enabled. When it does, a bundle
+ *  that failed to download or activate is reported with a code and message, even if
+ *  an earlier revision keeps /health happy
+ */
+func (opa *OpaRunner) checkStatus(client http.Client) error {
+
+	response, err := client.Get(opa.Config.GetAgentUrl() + "/v1/status")
+	if err != nil {
+		return nil
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+
+	log.Debug("OPA status (%d): %s", response.StatusCode, string(body))
+
+	if response.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var status struct {
+		Result struct {
+			Bundles map[string]struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"bundles"`
+		} `json:"result"`
+	}
+
+	if json.Unmarshal(body, &status) != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(status.Result.Bundles))
+	for name := range status.Result.Bundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		bundle := status.Result.Bundles[name]
+		if bundle.Code != "" {
+			return fmt.Errorf("OPA reports an error for bundle %s: %s (%s)", name, bundle.Message, bundle.Code)
+		}
+	}
 
 	return nil
 }
 
+/*
+ *  The last few lines of a file, or an explanation of why we couldn't read it
+ */
+func tailFile(filename string, lines int) string {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Sprintf("(unable to read %s: %s)", filename, err.Error())
+	}
+
+	all_lines := strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
+
+	if len(all_lines) > lines {
+		all_lines = all_lines[len(all_lines)-lines:]
+	}
+
+	return strings.Join(all_lines, "\n")
+}
+
+/*
+ *  Whether the OPA process we started has exited (of its own accord, or because
+ *  we stopped it)
+ */
+func (opa *OpaRunner) Exited() bool {
+
+	select {
+	case <-opa.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+ *  Ask OPA to shut down with SIGTERM, and give it a little while to do so before
+ *  we kill it. Either way, we wait for the process to be reaped, so nothing is
+ *  left holding the port when we return
+ */
 func (opa *OpaRunner) Stop() error {
 
 	if opa.Remote {
@@ -131,13 +474,92 @@ func (opa *OpaRunner) Stop() error {
 		return nil
 	}
 
+	if opa.Evaluator != nil {
+		log.Debug("OpaRunner:Stop() - decisions are evaluated in-process, there's no OPA to stop")
+		if closer, ok := opa.Evaluator.(interface{ Close() }); ok {
+			closer.Close()
+		}
+		return nil
+	}
+
 	if opa.Process == nil {
 		return fmt.Errorf("OpaRunner:Stop - no process found, can't stop, won't stop")
 	}
 
+	// the bundle server outlives OPA, so OPA never sees it disappear
+	defer opa.cleanup()
+
+	runningLock.Lock()
+	delete(running, opa)
+	runningLock.Unlock()
+
+	select {
+	case <-opa.exited:
+		log.Debug("OpaRunner:Stop() - process %d has already exited: %v", opa.Process.Pid, opa.State)
+		return nil
+	default:
+	}
+
 	log.Debug("OpaRunner:Stop() - stoppping process: %d", opa.Process.Pid)
 
-	opa.Process.Kill()
+	timeout := config.OpaShutdownTimeout
+
+	// SIGTERM isn't supported on Windows, so we go straight to Kill there
+	err := opa.Process.Signal(syscall.SIGTERM)
+	if err != nil {
+		log.Debug("OpaRunner:Stop() - SIGTERM failed (%s), killing process %d", err.Error(), opa.Process.Pid)
+		timeout = 0
+	}
+
+	select {
+	case <-opa.exited:
+		log.Debug("OpaRunner:Stop() - process %d exited: %v", opa.Process.Pid, opa.State)
+		return nil
+	case <-time.After(timeout):
+	}
+
+	if timeout > 0 {
+		log.Warning("OPA (process %d) did not exit within %v, killing it", opa.Process.Pid, timeout)
+	}
+
+	err = opa.Process.Kill()
+	if err != nil {
+		log.Error("Unable to kill OPA process %d: %s", opa.Process.Pid, err.Error())
+		return err
+	}
+
+	<-opa.exited
 
 	return nil
 }
+
+/*
+ *  Can we connect to something on this port already?
+ */
+func portInUse(port uint16) bool {
+
+	connection, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", port), 500*time.Millisecond)
+	if err != nil {
+		return false
+	}
+
+	connection.Close()
+
+	return true
+}
+
+/*
+ *  Ask the operating system for a free ephemeral port. There's a small window
+ *  between closing the listener and OPA binding to the port, but the startup
+ *  check (portInUse) catches the rare collision
+ */
+func FreePort() (uint16, error) {
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	return uint16(listener.Addr().(*net.TCPAddr).Port), nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func readinessRunner(t *testing.T, handler http.HandlerFunc) *OpaRunner {
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	log_path := filepath.Join(t.TempDir(), "opa.log")
+	os.WriteFile(log_path, []byte("starting\nbundle activation failed\n"), 0644)
+
+	return &OpaRunner{
+		Config: OpaConfig{EndpointUrl: server.URL, LogPath: log_path, StartupTimeout: 2 * time.Second},
+		exited: make(chan struct{}),
+	}
+}
+
+func TestWaitUntilReady_PollsUntilHealthy(t *testing.T) {
+
+	var calls atomic.Int32
+
+	runner := readinessRunner(t, func(w http.ResponseWriter, r *http.Request) {
+		// no status plugin
+		if r.URL.Path == "/v1/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path != "/health" || !r.URL.Query().Has("bundles") {
+			t.Errorf("unexpected readiness request %s", r.URL)
+		}
+		// not ready until the bundles have been activated
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	err := runner.waitUntilReady()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 health checks, got %d", calls.Load())
+	}
+}
+
+func TestWaitUntilReady_BundleError(t *testing.T) {
+
+	runner := readinessRunner(t, func(w http.ResponseWriter, r *http.Request) {
+		// healthy on the last revision, but the new one failed to activate
+		if r.URL.Path == "/v1/status" {
+			w.Write([]byte(`{"result":{"bundles":{"raygun":{"active_revision":"v1","code":"bundle_error","message":"signature verification failed"}}}}`))
+		}
+	})
+
+	err := runner.waitUntilReady()
+	if err == nil || !strings.Contains(err.Error(), "bundle raygun: signature verification failed (bundle_error)") || !strings.Contains(err.Error(), "bundle activation failed") {
+		t.Errorf("expected the bundle error with the tail of the OPA log, got %v", err)
+	}
+}
+
+func TestWaitUntilReady_Timeout(t *testing.T) {
+
+	runner := readinessRunner(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	runner.Config.StartupTimeout = 300 * time.Millisecond
+
+	err := runner.waitUntilReady()
+	if err == nil || !strings.Contains(err.Error(), "did not become healthy within 300ms") || !strings.Contains(err.Error(), "bundle activation failed") {
+		t.Errorf("expected a timeout with the tail of the OPA log, got %v", err)
+	}
+
+	if runner.Exited() {
+		t.Errorf("expected OPA to still be running after a timeout")
+	}
+}
+
+func TestWaitUntilReady_Exited(t *testing.T) {
+
+	runner := readinessRunner(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	close(runner.exited)
+
+	err := runner.waitUntilReady()
+	if err == nil || !strings.Contains(err.Error(), "OPA exited during startup") || !strings.Contains(err.Error(), "bundle activation failed") {
+		t.Errorf("expected an exit error with the tail of the OPA log, got %v", err)
+	}
+
+	if !runner.Exited() {
+		t.Errorf("expected OPA to have exited")
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  Signed bundle support. With a signing: section, OPA is started with bundle
+ *  signature verification switched on, so we test the signed artifact the way it's
+ *  deployed. The embedded and wasm engines verify the signature when they load it.
+ *
+ *  We can also make a tampered and an unsigned copy of the bundle, to check that
+ *  they're rejected.
+ */
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/bundle"
+)
+
+const DEFAULT_SIGNING_ALGORITHM = "RS256"
+const DEFAULT_VERIFICATION_KEY_ID = "default"
+
+const SIGNATURES_FILE = ".signatures.json"
+
+type SigningConfig struct {
+	VerificationKey string   `yaml:"verification-key"` // PEM text (or a ${} property holding it), a PEM file, or an HMAC secret
+	KeyId           string   `yaml:"key-id,omitempty"`
+	Scope           string   `yaml:"scope,omitempty"`
+	Algorithm       string   `yaml:"algorithm,omitempty"`
+	ExcludeFiles    []string `yaml:"exclude-files,omitempty"`
+	RejectionTests  bool     `yaml:"rejection-tests,omitempty"` // check that tampered and unsigned copies are rejected
+}
+
+func (sc SigningConfig) String() string {
+	return fmt.Sprintf("key-id: %s, scope: %s, algorithm: %s, exclude: %v, rejection-tests: %v", sc.keyId(), sc.Scope, sc.algorithm(), sc.ExcludeFiles, sc.RejectionTests)
+}
+
+/*
+ *  Tells two signing configs apart, without putting the key itself in a log
+ */
+func (sc *SigningConfig) Identity() string {
+
+	if sc == nil {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(sc.VerificationKey))
+
+	return fmt.Sprintf("%s, key: %s", sc.String(), hex.EncodeToString(sum[:8]))
+}
+
+func (sc SigningConfig) keyId() string {
+	if sc.KeyId == "" {
+		return DEFAULT_VERIFICATION_KEY_ID
+	}
+	return sc.KeyId
+}
+
+func (sc SigningConfig) algorithm() string {
+	if sc.Algorithm == "" {
+		return DEFAULT_SIGNING_ALGORITHM
+	}
+	return sc.Algorithm
+}
+
+func (sc SigningConfig) isPem() bool {
+	return strings.Contains(sc.VerificationKey, "-----BEGIN")
+}
+
+/*
+ *  The key itself, whichever way it was given to us
+ */
+func (sc SigningConfig) keyMaterial() (string, error) {
+
+	if sc.VerificationKey == "" {
+		return "", fmt.Errorf("signing: needs a verification-key")
+	}
+
+	if sc.isPem() {
+		return sc.VerificationKey, nil
+	}
+
+	if data, err := os.ReadFile(sc.VerificationKey); err == nil {
+		return string(data), nil
+	}
+
+	// not PEM, and not a file, so it's an HMAC secret
+	return sc.VerificationKey, nil
+}
+
+/*
+ *  The opa run flags that switch on verification. OPA wants the PEM in a file, so
+ *  PEM text is written to a temp file, which is returned so it can be cleaned up
+ */
+func (sc SigningConfig) verificationArguments() ([]string, string, error) {
+
+	key := sc.VerificationKey
+	key_file := ""
+
+	if key == "" {
+		return nil, "", fmt.Errorf("signing: needs a verification-key")
+	}
+
+	if sc.isPem() {
+
+		f, err := os.CreateTemp("", "raygun-verification-key-*.pem")
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to write verification key: %w", err)
+		}
+
+		_, err = f.WriteString(sc.VerificationKey)
+		f.Close()
+
+		if err != nil {
+			os.Remove(f.Name())
+			return nil, "", fmt.Errorf("unable to write verification key: %w", err)
+		}
+
+		key = f.Name()
+		key_file = f.Name()
+	}
+
+	args := []string{"--verification-key", key, "--verification-key-id", sc.keyId(), "--signing-alg", sc.algorithm()}
+
+	if sc.Scope != "" {
+		args = append(args, "--scope", sc.Scope)
+	}
+
+	if len(sc.ExcludeFiles) > 0 {
+		args = append(args, "--exclude-files-verify", strings.Join(sc.ExcludeFiles, ","))
+	}
+
+	return args, key_file, nil
+}
+
+/*
+ *  The keys: and bundle signing: entries for a generated OPA config file
+ */
+func (sc SigningConfig) serviceConfig() (map[string]interface{}, map[string]interface{}, error) {
+
+	material, err := sc.keyMaterial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := map[string]interface{}{
+		sc.keyId(): map[string]interface{}{"key": material, "algorithm": sc.algorithm()},
+	}
+
+	signing := map[string]interface{}{"keyid": sc.keyId()}
+
+	if sc.Scope != "" {
+		signing["scope"] = sc.Scope
+	}
+
+	if len(sc.ExcludeFiles) > 0 {
+		signing["exclude_files"] = sc.ExcludeFiles
+	}
+
+	return keys, signing, nil
+}
+
+/*
+ *  For the in-process engines, which verify the bundle as they load it
+ */
+func (sc SigningConfig) VerificationConfig() (*bundle.VerificationConfig, error) {
+
+	material, err := sc.keyMaterial()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*bundle.KeyConfig{
+		sc.keyId(): {Key: material, Algorithm: sc.algorithm()},
+	}
+
+	return bundle.NewVerificationConfig(keys, sc.keyId(), sc.Scope, sc.ExcludeFiles), nil
+}
+
+/*
+ *  A copy of the bundle with its policy and data files changed, but the signatures
+ *  left alone, so the digests no longer match. OPA compares data files as JSON, so
+ *  those get an extra key, not just extra whitespace
+ */
+func TamperedBundle(bundle_path string) (string, error) {
+
+	tampered := false
+
+	copy_path, err := rewriteBundle(bundle_path, "tampered", func(name string, data []byte) ([]byte, bool) {
+
+		switch {
+		case strings.HasSuffix(name, ".rego"):
+			tampered = true
+			return append(data, []byte("\n# tampered with by raygun\n")...), true
+
+		case filepath.Base(name) == "data.json":
+			var document map[string]interface{}
+			if json.Unmarshal(data, &document) != nil || document == nil {
+				return data, true
+			}
+			document["raygun_tampered"] = true
+			changed, err := json.Marshal(document)
+			if err != nil {
+				return data, true
+			}
+			tampered = true
+			return changed, true
+		}
+
+		return data, true
+	})
+
+	if err == nil && !tampered {
+		os.Remove(copy_path)
+		return "", fmt.Errorf("bundle %s has no policy or data files to tamper with", bundle_path)
+	}
+
+	return copy_path, err
+}
+
+/*
+ *  A copy of the bundle without its signatures
+ */
+func UnsignedBundle(bundle_path string) (string, error) {
+
+	return rewriteBundle(bundle_path, "unsigned", func(name string, data []byte) ([]byte, bool) {
+		return data, filepath.Base(name) != SIGNATURES_FILE
+	})
+}
+
+/*
+ *  Copy a bundle tar.gz to a temp file, passing every file through edit, which
+ *  returns the (possibly changed) content, and whether to keep the file at all
+ */
+func rewriteBundle(bundle_path string, label string, edit func(name string, data []byte) ([]byte, bool)) (string, error) {
+
+	f, err := os.Open(bundle_path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read bundle: %w", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", fmt.Errorf("bundle %s is not a tar.gz: %w", bundle_path, err)
+	}
+
+	var buffer bytes.Buffer
+
+	gz_writer := gzip.NewWriter(&buffer)
+	tar_writer := tar.NewWriter(gz_writer)
+	tar_reader := tar.NewReader(gz)
+
+	for {
+		header, err := tar_reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to read bundle %s: %w", bundle_path, err)
+		}
+
+		data, err := io.ReadAll(tar_reader)
+		if err != nil {
+			return "", err
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			var keep bool
+			data, keep = edit(header.Name, data)
+			if !keep {
+				continue
+			}
+			header.Size = int64(len(data))
+		}
+
+		err = tar_writer.WriteHeader(header)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = tar_writer.Write(data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := tar_writer.Close(); err != nil {
+		return "", err
+	}
+
+	if err := gz_writer.Close(); err != nil {
+		return "", err
+	}
+
+	out, err := os.CreateTemp("", fmt.Sprintf("raygun-%s-*.tar.gz", label))
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	_, err = out.Write(buffer.Bytes())
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+
+	return out.Name(), nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/compile"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ *  Sign the test bundle with a fresh RSA key, and return the bundle and the
+ *  public key, as PEM
+ */
+func writeSignedTestBundle(t *testing.T) (string, string) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private_pem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	public_der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public_pem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public_der})
+
+	bundle_path := filepath.Join(t.TempDir(), "signed.tar.gz")
+
+	out, err := os.Create(bundle_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	err = compile.New().
+		WithPaths(writeTestBundle(t)).
+		WithRegoVersion(ast.RegoV1).
+		WithBundleSigningConfig(bundle.NewSigningConfig(string(private_pem), DEFAULT_SIGNING_ALGORITHM, "")).
+		WithOutput(out).
+		Build(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bundle_path, string(public_pem)
+}
+
+func TestSigning_EmbeddedVerification(t *testing.T) {
+
+	bundle_path, public_key := writeSignedTestBundle(t)
+
+	signing := &SigningConfig{VerificationKey: public_key}
+
+	engine, err := NewEmbeddedEngine(bundle_path, signing)
+	if err != nil {
+		t.Fatalf("the signed bundle was rejected: %s", err.Error())
+	}
+
+	if _, err := engine.Evaluate("/v1/data/test/allow", `{"input": {"name": "ray"}}`); err != nil {
+		t.Errorf("unable to evaluate the signed bundle: %s", err.Error())
+	}
+
+	tampered, err := TamperedBundle(bundle_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tampered)
+
+	unsigned, err := UnsignedBundle(bundle_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(unsigned)
+
+	// without verification, both copies still load
+	for _, path := range []string{tampered, unsigned} {
+		if _, err := NewEmbeddedEngine(path, nil); err != nil {
+			t.Errorf("%s should load without verification: %s", path, err.Error())
+		}
+	}
+
+	if _, err := NewEmbeddedEngine(tampered, signing); err == nil || !strings.Contains(err.Error(), "digest") {
+		t.Errorf("expected a digest mismatch for the tampered bundle, got %v", err)
+	}
+
+	if _, err := NewEmbeddedEngine(unsigned, signing); err == nil || !strings.Contains(err.Error(), "signatures") {
+		t.Errorf("expected a missing signatures error for the unsigned bundle, got %v", err)
+	}
+}
+
+func TestSigning_Arguments(t *testing.T) {
+
+	signing := SigningConfig{VerificationKey: "-----BEGIN PUBLIC KEY-----\nnot really\n-----END PUBLIC KEY-----\n", Scope: "read",
+		ExcludeFiles: []string{"a.json", "b.json"}}
+
+	args, key_file, err := signing.verificationArguments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(key_file)
+
+	data, _ := os.ReadFile(key_file)
+
+	if string(data) != signing.VerificationKey {
+		t.Errorf("the key file doesn't hold the key: %q", string(data))
+	}
+
+	expected := "--verification-key " + key_file + " --verification-key-id default --signing-alg RS256 --scope read --exclude-files-verify a.json,b.json"
+
+	if strings.Join(args, " ") != expected {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+
+	// a secret is passed as it is, there's no file to write
+	signing = SigningConfig{VerificationKey: "secret", KeyId: "hmac", Algorithm: "HS256"}
+
+	args, key_file, err = signing.verificationArguments()
+	if err != nil || key_file != "" || strings.Join(args, " ") != "--verification-key secret --verification-key-id hmac --signing-alg HS256" {
+		t.Errorf("unexpected arguments for a secret: %v %q %v", args, key_file, err)
+	}
+}
+
+func TestWriteServiceConfig_Signing(t *testing.T) {
+
+	signing := &SigningConfig{VerificationKey: "secret", KeyId: "global", Algorithm: "HS256", Scope: "write"}
+
+	filename, err := writeServiceConfig("", "http://localhost:1234", BUNDLE_RESOURCE, 1, signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	data, _ := os.ReadFile(filename)
+
+	var document struct {
+		Keys    map[string]map[string]string `yaml:"keys"`
+		Bundles map[string]struct {
+			Signing map[string]string `yaml:"signing"`
+		} `yaml:"bundles"`
+	}
+
+	err = yaml.Unmarshal(data, &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if document.Keys["global"]["key"] != "secret" || document.Keys["global"]["algorithm"] != "HS256" {
+		t.Errorf("missing key: %s", string(data))
+	}
+
+	bundle_signing := document.Bundles[BUNDLE_SERVICE_NAME].Signing
+
+	if bundle_signing["keyid"] != "global" || bundle_signing["scope"] != "write" {
+		t.Errorf("missing bundle signing config: %s", string(data))
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  The wasm engine evaluates decisions with a Wasm-compiled policy, the way the
+ *  policy would run at the edge, so we can prove it gives the same answers as OPA.
+ *
+ *  If the bundle already contains policy.wasm (opa build -t wasm), that's what runs.
+ *  Otherwise the rego in the bundle is compiled to Wasm, once per decision path, with
+ *  the decision path as the entrypoint.
+ *
+ *  The Wasm runtime is wazero (pure Go), so there's nothing to install.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"raygun/log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/compile"
+)
+
+type WasmEngine struct {
+	BundlePath string
+	bundle     *bundle.Bundle
+	prebuilt   []*wasmPolicy          // from policy.wasm files in the bundle
+	compiled   map[string]*wasmPolicy // compiled by us, keyed by entrypoint
+	lock       sync.Mutex
+}
+
+func NewWasmEngine(bundle_path string, signing *SigningConfig) (*WasmEngine, error) {
+
+	log.Debug("Loading bundle %s for the wasm engine", bundle_path)
+
+	b, err := loadBundle(bundle_path, signing)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &WasmEngine{
+		BundlePath: bundle_path,
+		bundle:     b,
+		compiled:   make(map[string]*wasmPolicy),
+	}
+
+	for _, module := range b.WasmModules {
+
+		log.Debug("Using prebuilt wasm module %s", module.Path)
+
+		policy, err := newWasmPolicy(module.Raw, b.Data)
+		if err != nil {
+			engine.Close()
+			return nil, fmt.Errorf("unable to load wasm module %s: %w", module.Path, err)
+		}
+
+		engine.prebuilt = append(engine.prebuilt, policy)
+	}
+
+	return engine, nil
+}
+
+func (engine *WasmEngine) Evaluate(decision_path string, body string) (string, error) {
+
+	entrypoint, err := WasmEntrypoint(decision_path)
+	if err != nil {
+		return "", err
+	}
+
+	policy, err := engine.policyFor(entrypoint)
+	if err != nil {
+		return "", err
+	}
+
+	var raw_input []byte
+
+	input, found, err := inputFromBody(body)
+	if err != nil {
+		return "", err
+	}
+
+	if found {
+		raw_input, err = json.Marshal(input)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	raw_result, err := policy.eval(entrypoint, raw_input)
+	if err != nil {
+		return "", fmt.Errorf("wasm evaluation of %s failed: %w", decision_path, err)
+	}
+
+	// the policy answers with a result set, [{"result": ...}], or [] when undefined
+	result_set := make([]map[string]json.RawMessage, 0)
+
+	err = json.Unmarshal(raw_result, &result_set)
+	if err != nil {
+		return "", fmt.Errorf("unexpected wasm result for %s: %s", decision_path, string(raw_result))
+	}
+
+	response := make(map[string]json.RawMessage)
+
+	if len(result_set) > 0 {
+		if result, found := result_set[0]["result"]; found {
+			response["result"] = result
+		}
+	}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug("Wasm response for %s: %s", decision_path, string(b))
+
+	return string(b), nil
+}
+
+/*
+ *  Release the wasm runtimes
+ */
+func (engine *WasmEngine) Close() {
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	for _, policy := range engine.prebuilt {
+		policy.close()
+	}
+
+	for _, policy := range engine.compiled {
+		policy.close()
+	}
+
+	engine.prebuilt = nil
+	engine.compiled = make(map[string]*wasmPolicy)
+}
+
+/*
+ *  Find (or build) the policy that has the entrypoint
+ */
+func (engine *WasmEngine) policyFor(entrypoint string) (*wasmPolicy, error) {
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	if len(engine.prebuilt) > 0 {
+
+		available := make([]string, 0)
+
+		for _, policy := range engine.prebuilt {
+			if _, found := policy.entrypoints[entrypoint]; found {
+				return policy, nil
+			}
+			for name := range policy.entrypoints {
+				available = append(available, name)
+			}
+		}
+
+		sort.Strings(available)
+
+		return nil, fmt.Errorf("entrypoint %s is not in the bundle's policy.wasm (available: %s)", entrypoint, strings.Join(available, ", "))
+	}
+
+	if policy, found := engine.compiled[entrypoint]; found {
+		return policy, nil
+	}
+
+	log.Debug("Compiling %s to wasm with entrypoint %s", engine.BundlePath, entrypoint)
+
+	// the compiler adds the wasm module to the bundle it's given, so give it a copy
+	b := engine.bundle.Copy()
+
+	compiler := compile.New().
+		WithTarget(compile.TargetWasm).
+		WithEntrypoints(entrypoint).
+		WithRegoVersion(ast.RegoV1).
+		WithBundle(&b)
+
+	err := compiler.Build(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile %s to wasm for entrypoint %s: %w", engine.BundlePath, entrypoint, err)
+	}
+
+	wasm_modules := compiler.Bundle().WasmModules
+	if len(wasm_modules) == 0 {
+		return nil, fmt.Errorf("compiling %s to wasm produced no module", engine.BundlePath)
+	}
+
+	policy, err := newWasmPolicy(wasm_modules[0].Raw, engine.bundle.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	engine.compiled[entrypoint] = policy
+
+	return policy, nil
+}
+
+/*
+ *  /v1/data/a/b -> a/b, the way opa build names entrypoints
+ */
+func WasmEntrypoint(decision_path string) (string, error) {
+
+	ref, err := DecisionRef(decision_path)
+	if err != nil {
+		return "", err
+	}
+
+	if len(ref) < 2 {
+		return "", fmt.Errorf("the wasm engine needs a decision path below /v1/data: %s", decision_path)
+	}
+
+	segments := make([]string, 0, len(ref)-1)
+
+	for _, term := range ref[1:] {
+		segment, ok := term.Value.(ast.String)
+		if !ok {
+			return "", fmt.Errorf("unsupported decision path for the wasm engine: %s", decision_path)
+		}
+		segments = append(segments, string(segment))
+	}
+
+	return strings.Join(segments, "/"), nil
+}
@@ -0,0 +1,566 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+/*
+ *  Just enough of the OPA Wasm ABI (https://www.openpolicyagent.org/docs/latest/wasm/)
+ *  to load a policy, hand it data and input, and call opa_eval.
+ *
+ *  OPA policies import their memory from "env", and wazero host modules can't export
+ *  memory. So we build a tiny "env" module on the fly: it defines the memory and
+ *  re-exports the host functions (opa_abort, opa_println, opa_builtinN) that the
+ *  policy imports.
+ */
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"raygun/log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/topdown"
+	"github.com/open-policy-agent/opa/v1/topdown/builtins"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+const WASM_PAGE_SIZE = 65536
+
+// opa_eval arrived in ABI 1.2
+const WASM_ABI_MAJOR_VERSION = 1
+const WASM_ABI_MINIMUM_MINOR_VERSION = 2
+
+const WASM_HOST_MODULE = "raygun"
+
+type wasmPolicy struct {
+	runtime     wazero.Runtime
+	module      api.Module
+	memory      api.Memory
+	entrypoints map[string]int32
+	builtins    map[int32]topdown.BuiltinFunc
+	builtinCtx  topdown.BuiltinContext
+	dataAddr    uint32
+	heapPtr     uint32 // where each evaluation starts, just past the data
+	lock        sync.Mutex
+}
+
+func newWasmPolicy(raw []byte, data map[string]interface{}) (*wasmPolicy, error) {
+
+	ctx := context.Background()
+
+	policy := &wasmPolicy{
+		runtime: wazero.NewRuntime(ctx),
+	}
+
+	err := policy.instantiate(ctx, raw)
+	if err != nil {
+		policy.close()
+		return nil, err
+	}
+
+	err = policy.initialize(ctx, data)
+	if err != nil {
+		policy.close()
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (policy *wasmPolicy) close() {
+	policy.runtime.Close(context.Background())
+}
+
+func (policy *wasmPolicy) instantiate(ctx context.Context, raw []byte) error {
+
+	compiled, err := policy.runtime.CompileModule(ctx, raw)
+	if err != nil {
+		return fmt.Errorf("invalid wasm module: %w", err)
+	}
+
+	host := policy.runtime.NewHostModuleBuilder(WASM_HOST_MODULE)
+	env := &wasmEnvModule{}
+
+	for _, definition := range compiled.ImportedFunctions() {
+
+		module_name, name, _ := definition.Import()
+		if module_name != "env" {
+			return fmt.Errorf("wasm module imports %s.%s, which isn't part of the OPA ABI", module_name, name)
+		}
+
+		var fn api.GoModuleFunc
+
+		switch name {
+		case "opa_abort":
+			fn = policy.abort
+		case "opa_println":
+			fn = policy.println
+		case "opa_builtin0", "opa_builtin1", "opa_builtin2", "opa_builtin3", "opa_builtin4":
+			fn = policy.callBuiltin
+		default:
+			return fmt.Errorf("wasm module imports env.%s, which isn't part of the OPA ABI", name)
+		}
+
+		host.NewFunctionBuilder().WithGoModuleFunction(fn, definition.ParamTypes(), definition.ResultTypes()).Export(name)
+		env.addFunction(name, definition.ParamTypes(), definition.ResultTypes())
+	}
+
+	for _, definition := range compiled.ImportedMemories() {
+		_, name, _ := definition.Import()
+		max, bounded := definition.Max()
+		env.setMemory(name, definition.Min(), max, bounded)
+	}
+
+	_, err = host.Instantiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = policy.runtime.InstantiateWithConfig(ctx, env.encode(), wazero.NewModuleConfig().WithName("env"))
+	if err != nil {
+		return fmt.Errorf("unable to create the wasm env module: %w", err)
+	}
+
+	policy.module, err = policy.runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName("policy"))
+	if err != nil {
+		return fmt.Errorf("unable to instantiate wasm module: %w", err)
+	}
+
+	// the policy re-exports the memory it imports
+	policy.memory = policy.module.Memory()
+	if policy.memory == nil {
+		return fmt.Errorf("wasm module has no memory")
+	}
+
+	major := policy.module.ExportedGlobal("opa_wasm_abi_version")
+	minor := policy.module.ExportedGlobal("opa_wasm_abi_minor_version")
+
+	if major == nil || minor == nil {
+		return fmt.Errorf("wasm module doesn't declare an OPA ABI version")
+	}
+
+	major_version := api.DecodeI32(major.Get())
+	minor_version := api.DecodeI32(minor.Get())
+
+	if major_version != WASM_ABI_MAJOR_VERSION || minor_version < WASM_ABI_MINIMUM_MINOR_VERSION {
+		return fmt.Errorf("wasm module uses OPA ABI %d.%d, and raygun needs %d.%d or later", major_version, minor_version, WASM_ABI_MAJOR_VERSION, WASM_ABI_MINIMUM_MINOR_VERSION)
+	}
+
+	return nil
+}
+
+/*
+ *  Load the data document, and read the builtin and entrypoint tables
+ */
+func (policy *wasmPolicy) initialize(ctx context.Context, data map[string]interface{}) error {
+
+	// the first malloc initializes the heap
+	_, err := policy.call(ctx, "opa_malloc", 0)
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+
+	raw_data, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	addr, err := policy.write(ctx, raw_data)
+	if err != nil {
+		return err
+	}
+
+	policy.dataAddr, err = policy.call(ctx, "opa_json_parse", addr, uint32(len(raw_data)))
+	if err != nil {
+		return err
+	}
+
+	if policy.dataAddr == 0 {
+		return fmt.Errorf("wasm module was unable to parse the bundle data")
+	}
+
+	policy.heapPtr, err = policy.call(ctx, "opa_heap_ptr_get")
+	if err != nil {
+		return err
+	}
+
+	builtin_ids := make(map[string]int32)
+	err = policy.readTable(ctx, "builtins", &builtin_ids)
+	if err != nil {
+		return err
+	}
+
+	policy.builtins = make(map[int32]topdown.BuiltinFunc)
+
+	for name, id := range builtin_ids {
+		fn := topdown.GetBuiltin(name)
+		if fn == nil {
+			return fmt.Errorf("wasm module needs builtin %s, which this version of OPA doesn't have", name)
+		}
+		policy.builtins[id] = fn
+	}
+
+	policy.entrypoints = make(map[string]int32)
+
+	return policy.readTable(ctx, "entrypoints", &policy.entrypoints)
+}
+
+/*
+ *  Evaluate an entrypoint. The input is written just past the data, and the heap
+ *  is reset to that point every time, so nothing leaks between evaluations
+ */
+func (policy *wasmPolicy) eval(entrypoint string, input []byte) ([]byte, error) {
+
+	policy.lock.Lock()
+	defer policy.lock.Unlock()
+
+	id, found := policy.entrypoints[entrypoint]
+	if !found {
+		return nil, fmt.Errorf("entrypoint %s not found in wasm module", entrypoint)
+	}
+
+	ctx := context.Background()
+
+	policy.builtinCtx = topdown.BuiltinContext{
+		Context: ctx,
+		Metrics: metrics.New(),
+		Seed:    rand.Reader,
+		Time:    ast.NumberTerm(json.Number(strconv.FormatInt(time.Now().UnixNano(), 10))),
+		Cancel:  topdown.NewCancel(),
+		Cache:   make(builtins.Cache),
+	}
+
+	heap_ptr := policy.heapPtr
+	input_addr := uint32(0)
+	input_len := uint32(len(input))
+
+	if input != nil {
+
+		input_addr = heap_ptr
+		heap_ptr += input_len
+
+		err := policy.ensureMemory(heap_ptr)
+		if err != nil {
+			return nil, err
+		}
+
+		policy.memory.Write(input_addr, input)
+	}
+
+	// 0 = reserved, and the last 0 asks for JSON output
+	result_addr, err := policy.call(ctx, "opa_eval", 0, uint32(id), policy.dataAddr, input_addr, input_len, heap_ptr, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy.readString(result_addr)
+}
+
+func (policy *wasmPolicy) call(ctx context.Context, name string, params ...uint32) (uint32, error) {
+
+	fn := policy.module.ExportedFunction(name)
+	if fn == nil {
+		return 0, fmt.Errorf("wasm module doesn't export %s", name)
+	}
+
+	encoded := make([]uint64, len(params))
+	for i, p := range params {
+		encoded[i] = api.EncodeU32(p)
+	}
+
+	results, err := fn.Call(ctx, encoded...)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	return api.DecodeU32(results[0]), nil
+}
+
+/*
+ *  Copy bytes into memory allocated by the policy
+ */
+func (policy *wasmPolicy) write(ctx context.Context, raw []byte) (uint32, error) {
+
+	addr, err := policy.call(ctx, "opa_malloc", uint32(len(raw)))
+	if err != nil {
+		return 0, err
+	}
+
+	if !policy.memory.Write(addr, raw) {
+		return 0, fmt.Errorf("wasm malloc returned memory out of range")
+	}
+
+	return addr, nil
+}
+
+func (policy *wasmPolicy) ensureMemory(size uint32) error {
+
+	if size <= policy.memory.Size() {
+		return nil
+	}
+
+	pages := (size - policy.memory.Size() + WASM_PAGE_SIZE - 1) / WASM_PAGE_SIZE
+
+	if _, ok := policy.memory.Grow(pages); !ok {
+		return fmt.Errorf("unable to grow wasm memory by %d pages", pages)
+	}
+
+	return nil
+}
+
+/*
+ *  Strings handed back by the policy are NUL terminated
+ */
+func (policy *wasmPolicy) readString(addr uint32) ([]byte, error) {
+
+	if addr == 0 || addr >= policy.memory.Size() {
+		return nil, fmt.Errorf("wasm module returned an invalid address")
+	}
+
+	data, _ := policy.memory.Read(addr, policy.memory.Size()-addr)
+
+	n := bytes.IndexByte(data, 0)
+	if n < 0 {
+		return nil, fmt.Errorf("wasm string at %d isn't terminated", addr)
+	}
+
+	return bytes.Clone(data[:n]), nil
+}
+
+/*
+ *  builtins and entrypoints both return a JSON object of name -> id
+ */
+func (policy *wasmPolicy) readTable(ctx context.Context, name string, table *map[string]int32) error {
+
+	value_addr, err := policy.call(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	json_addr, err := policy.call(ctx, "opa_json_dump", value_addr)
+	if err != nil {
+		return err
+	}
+
+	raw, err := policy.readString(json_addr)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, table)
+}
+
+/*
+ *  Host functions
+ */
+
+func (policy *wasmPolicy) abort(ctx context.Context, _ api.Module, stack []uint64) {
+
+	message, err := policy.readString(api.DecodeU32(stack[0]))
+	if err != nil {
+		panic(fmt.Errorf("opa_abort: %w", err))
+	}
+
+	// wazero turns the panic into an error returned from the call
+	panic(fmt.Errorf("opa_abort: %s", string(message)))
+}
+
+func (policy *wasmPolicy) println(ctx context.Context, _ api.Module, stack []uint64) {
+
+	// print() output, which belongs with the debug logs, not in the report
+	message, err := policy.readString(api.DecodeU32(stack[0]))
+	if err == nil {
+		log.Debug("wasm policy: %s", string(message))
+	}
+}
+
+/*
+ *  opa_builtinN(id, ctx, arg1 ... argN). Anything the policy can't do natively is
+ *  handed to OPA's own implementation
+ */
+func (policy *wasmPolicy) callBuiltin(ctx context.Context, _ api.Module, stack []uint64) {
+
+	id := api.DecodeI32(stack[0])
+
+	fn, found := policy.builtins[id]
+	if !found {
+		panic(fmt.Errorf("wasm module called unknown builtin %d", id))
+	}
+
+	args := make([]*ast.Term, 0, len(stack)-2)
+
+	for _, value := range stack[2:] {
+		term, err := policy.fromValue(ctx, api.DecodeU32(value))
+		if err != nil {
+			panic(err)
+		}
+		args = append(args, term)
+	}
+
+	var output *ast.Term
+
+	err := fn(policy.builtinCtx, args, func(t *ast.Term) error {
+		output = t
+		return nil
+	})
+
+	if err != nil && errors.As(err, &topdown.Halt{}) {
+		panic(err)
+	}
+
+	// other errors are undefined, just like OPA without strict builtin errors
+	if output == nil {
+		stack[0] = 0
+		return
+	}
+
+	addr, err := policy.toValue(ctx, output)
+	if err != nil {
+		panic(err)
+	}
+
+	stack[0] = api.EncodeU32(addr)
+}
+
+func (policy *wasmPolicy) fromValue(ctx context.Context, addr uint32) (*ast.Term, error) {
+
+	dumped, err := policy.call(ctx, "opa_value_dump", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := policy.readString(dumped)
+	if err != nil {
+		return nil, err
+	}
+
+	return ast.ParseTerm(string(raw))
+}
+
+func (policy *wasmPolicy) toValue(ctx context.Context, term *ast.Term) (uint32, error) {
+
+	raw := []byte(term.String())
+
+	addr, err := policy.write(ctx, raw)
+	if err != nil {
+		return 0, err
+	}
+
+	return policy.call(ctx, "opa_value_parse", addr, uint32(len(raw)))
+}
+
+/*
+ *  The "env" module: a memory, plus the host functions re-exported under the names
+ *  the policy imports them by. Encoded by hand, since it's tiny
+ */
+type wasmEnvModule struct {
+	types      [][]byte
+	imports    [][]byte
+	exports    [][]byte
+	memory     []byte
+	memoryName string
+}
+
+func (env *wasmEnvModule) addFunction(name string, params []api.ValueType, results []api.ValueType) {
+
+	index := uint32(len(env.imports))
+
+	signature := []byte{0x60}
+	signature = append(signature, wasmVector(valueTypes(params))...)
+	signature = append(signature, wasmVector(valueTypes(results))...)
+	env.types = append(env.types, signature)
+
+	entry := append(wasmName(WASM_HOST_MODULE), wasmName(name)...)
+	entry = append(entry, 0x00)
+	entry = append(entry, wasmUleb(index)...)
+	env.imports = append(env.imports, entry)
+
+	export := append(wasmName(name), 0x00)
+	export = append(export, wasmUleb(index)...)
+	env.exports = append(env.exports, export)
+}
+
+func (env *wasmEnvModule) setMemory(name string, min uint32, max uint32, bounded bool) {
+
+	env.memoryName = name
+
+	if bounded {
+		env.memory = append([]byte{0x01}, wasmUleb(min)...)
+		env.memory = append(env.memory, wasmUleb(max)...)
+		return
+	}
+
+	env.memory = append([]byte{0x00}, wasmUleb(min)...)
+}
+
+func (env *wasmEnvModule) encode() []byte {
+
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+
+	module = append(module, wasmSection(1, wasmVectorOf(env.types))...)
+	module = append(module, wasmSection(2, wasmVectorOf(env.imports))...)
+
+	exports := env.exports
+
+	if env.memory != nil {
+		module = append(module, wasmSection(5, wasmVectorOf([][]byte{env.memory}))...)
+		exports = append(exports, append(wasmName(env.memoryName), 0x02, 0x00))
+	}
+
+	module = append(module, wasmSection(7, wasmVectorOf(exports))...)
+
+	return module
+}
+
+func wasmUleb(n uint32) []byte {
+
+	encoded := make([]byte, 0, 5)
+
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(encoded, b)
+		}
+		encoded = append(encoded, b|0x80)
+	}
+}
+
+func wasmName(name string) []byte {
+	return append(wasmUleb(uint32(len(name))), name...)
+}
+
+func wasmVector(items []byte) []byte {
+	return append(wasmUleb(uint32(len(items))), items...)
+}
+
+func wasmVectorOf(items [][]byte) []byte {
+	return append(wasmUleb(uint32(len(items))), bytes.Join(items, nil)...)
+}
+
+func wasmSection(id byte, content []byte) []byte {
+	section := append([]byte{id}, wasmUleb(uint32(len(content)))...)
+	return append(section, content...)
+}
+
+// api.ValueType is already the wasm encoding of the type
+func valueTypes(types []api.ValueType) []byte {
+	return []byte(types)
+}
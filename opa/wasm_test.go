@@ -0,0 +1,157 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package opa
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/compile"
+)
+
+const testPolicy = `package test
+
+default allow := false
+
+allow if input.name == "ray"
+
+# sprintf and upper aren't native to the wasm runtime, so these go through the host
+greeting := sprintf("hello %s", [upper(input.name)])
+
+limit := data.limits[input.name]
+`
+
+const testData = `{"limits": {"ray": 3}}`
+
+func writeTestBundle(t *testing.T) string {
+
+	directory := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(directory, "test.rego"), []byte(testPolicy), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(directory, "data.json"), []byte(testData), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return directory
+}
+
+func TestWasmEntrypoint(t *testing.T) {
+
+	tests := map[string]string{
+		"/v1/data/envoy/authz/allow": "envoy/authz/allow",
+		"/v0/data/example":           "example",
+		"v1/data/a/b/":               "a/b",
+	}
+
+	for decision_path, want := range tests {
+		got, err := WasmEntrypoint(decision_path)
+		if err != nil || got != want {
+			t.Errorf("WasmEntrypoint(%s) = %s, %v, want %s", decision_path, got, err, want)
+		}
+	}
+
+	if _, err := WasmEntrypoint("/v1/data"); err == nil {
+		t.Errorf("expected an error for the root of data")
+	}
+}
+
+func TestWasmEngine_MatchesEmbedded(t *testing.T) {
+
+	bundle_path := writeTestBundle(t)
+
+	wasm, err := NewWasmEngine(bundle_path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wasm.Close()
+
+	embedded, err := NewEmbeddedEngine(bundle_path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		decision_path string
+		body          string
+	}{
+		{"/v1/data/test/allow", `{"input": {"name": "ray"}}`},
+		{"/v1/data/test/allow", `{"input": {"name": "bob"}}`},
+		{"/v1/data/test/greeting", `{"input": {"name": "ray"}}`},
+		{"/v1/data/test/limit", `{"input": {"name": "ray"}}`},
+		{"/v1/data/test/limit", `{"input": {"name": "bob"}}`},
+		{"/v1/data/test/allow", ``},
+	}
+
+	for _, test := range tests {
+
+		want, err := embedded.Evaluate(test.decision_path, test.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// ask twice, to make sure nothing carries over from the last evaluation
+		for i := 0; i < 2; i++ {
+			got, err := wasm.Evaluate(test.decision_path, test.body)
+			if err != nil {
+				t.Fatalf("%s %s: %v", test.decision_path, test.body, err)
+			}
+			if got != want {
+				t.Errorf("%s %s: wasm = %s, embedded = %s", test.decision_path, test.body, got, want)
+			}
+		}
+	}
+}
+
+func TestWasmEngine_PrebuiltModule(t *testing.T) {
+
+	var buffer bytes.Buffer
+
+	compiler := compile.New().
+		WithTarget(compile.TargetWasm).
+		WithEntrypoints("test/allow").
+		WithRegoVersion(ast.RegoV1).
+		WithPaths(writeTestBundle(t)).
+		WithOutput(&buffer)
+
+	err := compiler.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle_path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+
+	err = os.WriteFile(bundle_path, buffer.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := NewWasmEngine(bundle_path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+
+	got, err := engine.Evaluate("/v1/data/test/allow", `{"input": {"name": "ray"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != `{"result":true}` {
+		t.Errorf("unexpected result: %s", got)
+	}
+
+	// only the entrypoints built into policy.wasm are available
+	if _, err := engine.Evaluate("/v1/data/test/greeting", `{"input": {}}`); err == nil {
+		t.Errorf("expected an error for an entrypoint that isn't in policy.wasm")
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+/*
+ *  admission-review inputs point at Kubernetes manifests. A manifest file may
+ *  contain several YAML documents, and each document becomes its own test, so
+ *  a deny on one object can't hide behind an allow on another.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"raygun/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+func expandAdmissionTests(suite *types.TestSuite) error {
+
+	expanded := make([]types.TestRecord, 0, len(suite.Tests))
+
+	for _, test := range suite.Tests {
+
+		if test.Input.InputType != "admission-review" {
+			expanded = append(expanded, test)
+			continue
+		}
+
+		admission := test.Input.Admission
+
+		if admission.Manifest == "" {
+			return fmt.Errorf("test %s: admission-review input requires a manifest", test.Name)
+		}
+
+		objects, err := readManifest(suiteRelative(*suite, admission.Manifest))
+		if err != nil {
+			return fmt.Errorf("test %s: %w", test.Name, err)
+		}
+
+		old_objects := make([]map[string]interface{}, 0)
+
+		if admission.OldManifest != "" {
+			old_objects, err = readManifest(suiteRelative(*suite, admission.OldManifest))
+			if err != nil {
+				return fmt.Errorf("test %s: %w", test.Name, err)
+			}
+
+			if len(old_objects) != 1 && len(old_objects) != len(objects) {
+				return fmt.Errorf("test %s: old-manifest has %d documents, but manifest has %d", test.Name, len(old_objects), len(objects))
+			}
+		}
+
+		for i, object := range objects {
+
+			document_test := test
+			document_test.Input.Admission.Object = object
+
+			if len(old_objects) == 1 {
+				document_test.Input.Admission.OldObject = old_objects[0]
+			} else if len(old_objects) > 0 {
+				document_test.Input.Admission.OldObject = old_objects[i]
+			}
+
+			if len(objects) > 1 {
+				document_test.Name = fmt.Sprintf("%s [%s]", test.Name, describeObject(object))
+			}
+
+			expanded = append(expanded, document_test)
+		}
+	}
+
+	suite.Tests = expanded
+
+	return nil
+}
+
+/*
+ *  Read every non-empty document from a (possibly multi-document) manifest
+ */
+func readManifest(filename string) ([]map[string]interface{}, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+
+	objects := make([]map[string]interface{}, 0)
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var object map[string]interface{}
+
+		err := decoder.Decode(&object)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse manifest %s: %w", filename, err)
+		}
+
+		// a leading or trailing --- produces an empty document
+		if len(object) == 0 {
+			continue
+		}
+
+		objects = append(objects, object)
+	}
+
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("manifest %s contains no objects", filename)
+	}
+
+	return objects, nil
+}
+
+func describeObject(object map[string]interface{}) string {
+
+	name := ""
+	if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+		name = fmt.Sprintf("%v", metadata["name"])
+	}
+
+	return fmt.Sprintf("%v/%s", object["kind"], name)
+}
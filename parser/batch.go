@@ -0,0 +1,150 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+/*
+ *  json-glob inputs point at a directory of input files, and every file becomes
+ *  its own test. The expectations for each file come from (in order):
+ *
+ *    1. a sidecar file next to the input: attack-1.json -> attack-1.expects.yaml
+ *    2. the expects: section of the test
+ *    3. the naming convention - a file or directory name starting with allow or
+ *       deny becomes an 'allowed: true' or 'allowed: false' expectation
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"raygun/types"
+	"raygun/util"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var SIDECAR_EXTENSIONS = []string{".expects.yaml", ".expects.yml"}
+
+func (parser *RaygunParser) expandBatchTests(suite *types.TestSuite) error {
+
+	expanded := make([]types.TestRecord, 0, len(suite.Tests))
+
+	for _, test := range suite.Tests {
+
+		if test.Input.InputType != "json-glob" {
+			expanded = append(expanded, test)
+			continue
+		}
+
+		globbed, err := filepath.Glob(filepath.Join(suite.Directory, test.Input.Value))
+		if err != nil {
+			return fmt.Errorf("test %s: invalid input glob %s: %w", test.Name, test.Input.Value, err)
+		}
+
+		// a glob like inputs/* also finds the sidecars, which aren't inputs
+		matches := make([]string, 0, len(globbed))
+		for _, match := range globbed {
+			if !isSidecar(match) {
+				matches = append(matches, match)
+			}
+		}
+
+		if len(matches) == 0 {
+			return fmt.Errorf("test %s: input glob %s matched no files", test.Name, test.Input.Value)
+		}
+
+		sort.Strings(matches)
+
+		for _, match := range matches {
+
+			relative, err := filepath.Rel(suite.Directory, match)
+			if err != nil {
+				return err
+			}
+
+			file_test := test
+			file_test.Name = fmt.Sprintf("%s/%s", test.Name, filepath.Base(match))
+			file_test.Input = types.TestInput{InputType: "json-file", Value: relative}
+
+			err = parser.batchExpectations(&file_test, match)
+			if err != nil {
+				return fmt.Errorf("test %s: %w", file_test.Name, err)
+			}
+
+			expanded = append(expanded, file_test)
+		}
+	}
+
+	suite.Tests = expanded
+
+	return nil
+}
+
+func isSidecar(filename string) bool {
+
+	for _, extension := range SIDECAR_EXTENSIONS {
+		if strings.HasSuffix(filename, extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (parser *RaygunParser) batchExpectations(test *types.TestRecord, input_file string) error {
+
+	if len(test.Decisions) > 0 {
+		// each decision brings its own expectations
+		return nil
+	}
+
+	base := strings.TrimSuffix(input_file, filepath.Ext(input_file))
+
+	for _, extension := range SIDECAR_EXTENSIONS {
+
+		data, err := os.ReadFile(base + extension)
+		if err != nil {
+			continue
+		}
+
+		var expects interface{}
+		err = yaml.Unmarshal(data, &expects)
+		if err != nil {
+			return fmt.Errorf("unable to parse sidecar %s: %w", base+extension, err)
+		}
+
+		test.ExpectData = nil
+
+		if util.IsArray(expects) {
+			return parser.yamlToExpectationsArray(test, expects.([]interface{}))
+		} else if util.IsMap(expects) {
+			return parser.yamlToExpectationsMap(test, expects.(map[string]interface{}))
+		}
+
+		return fmt.Errorf("sidecar %s must contain an expects map or list", base+extension)
+	}
+
+	if len(test.ExpectData) > 0 {
+		return nil
+	}
+
+	// the file name wins over the directory name: inputs/deny/allow-1.json is an allow
+	for _, name := range []string{filepath.Base(input_file), filepath.Base(filepath.Dir(input_file))} {
+
+		name = strings.ToLower(name)
+
+		if strings.HasPrefix(name, "allow") {
+			test.ExpectData = []types.TestExpectation{{ExpectationType: "allowed", Target: "true"}}
+			return nil
+		}
+
+		if strings.HasPrefix(name, "deny") {
+			test.ExpectData = []types.TestExpectation{{ExpectationType: "allowed", Target: "false"}}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no expectation found (add an expects section, a sidecar file, or use an allow/deny file name)")
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"raygun/types"
+	"strings"
+	"testing"
+)
+
+func batchSuite(t *testing.T, files map[string]string, glob string) types.TestSuite {
+
+	directory := t.TempDir()
+
+	for name, content := range files {
+		path := filepath.Join(directory, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	test := types.TestRecord{Name: "batch", Input: types.TestInput{InputType: "json-glob", Value: glob}}
+
+	return types.TestSuite{Directory: directory, Tests: []types.TestRecord{test}}
+}
+
+func TestExpandBatchTests(t *testing.T) {
+
+	suite := batchSuite(t, map[string]string{
+		"inputs/allow-1.json":     `{}`,
+		"inputs/odd.json":         `{}`,
+		"inputs/odd.expects.yaml": "substring: quarantined\n",
+	}, "inputs/*.json")
+
+	parser := NewRaygunParser(false)
+
+	err := parser.expandBatchTests(&suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]types.TestExpectation{
+		"batch/allow-1.json": {ExpectationType: "allowed", Target: "true"},
+		"batch/odd.json":     {ExpectationType: "substring", Target: "quarantined"},
+	}
+
+	if len(suite.Tests) != len(expected) {
+		t.Fatalf("expected %d tests, got %v", len(expected), suite.Tests)
+	}
+
+	for _, test := range suite.Tests {
+
+		if test.Input.InputType != "json-file" || !strings.HasPrefix(test.Input.Value, "inputs") {
+			t.Errorf("%s: expected a json-file input relative to the suite, got %v", test.Name, test.Input)
+		}
+
+		if len(test.ExpectData) != 1 || test.ExpectData[0].ExpectationType != expected[test.Name].ExpectationType || test.ExpectData[0].Target != expected[test.Name].Target {
+			t.Errorf("%s: unexpected expectations %v", test.Name, test.ExpectData)
+		}
+	}
+}
+
+func TestExpandBatchTests_SidecarsAreNotInputs(t *testing.T) {
+
+	suite := batchSuite(t, map[string]string{
+		"inputs/first.json":         `{}`,
+		"inputs/first.expects.yaml": "allowed: true\n",
+		"inputs/second.json":        `{}`,
+		"inputs/second.expects.yml": "allowed: false\n",
+	}, "inputs/*")
+
+	parser := NewRaygunParser(false)
+
+	err := parser.expandBatchTests(&suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(suite.Tests) != 2 || suite.Tests[0].Name != "batch/first.json" || suite.Tests[1].Name != "batch/second.json" {
+		t.Fatalf("expected only the json inputs, got %v", suite.Tests)
+	}
+
+	if suite.Tests[1].ExpectData[0].Target != "false" {
+		t.Errorf("expected the .expects.yml sidecar to be used, got %v", suite.Tests[1].ExpectData)
+	}
+}
+
+func TestExpandBatchTests_DirectoryConvention(t *testing.T) {
+
+	suite := batchSuite(t, map[string]string{
+		"inputs/deny/attack.json":      `{}`,
+		"inputs/deny/allow-later.json": `{}`,
+	}, "inputs/deny/*.json")
+
+	parser := NewRaygunParser(false)
+
+	err := parser.expandBatchTests(&suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file name wins over the directory name
+	targets := map[string]string{"batch/allow-later.json": "true", "batch/attack.json": "false"}
+
+	for _, test := range suite.Tests {
+		if test.ExpectData[0].Target != targets[test.Name] {
+			t.Errorf("%s: expected allowed %s, got %v", test.Name, targets[test.Name], test.ExpectData)
+		}
+	}
+}
+
+func TestExpandBatchTests_Errors(t *testing.T) {
+
+	parser := NewRaygunParser(false)
+
+	suite := batchSuite(t, map[string]string{"inputs/x.json": `{}`}, "missing/*.json")
+
+	err := parser.expandBatchTests(&suite)
+	if err == nil || !strings.Contains(err.Error(), "matched no files") {
+		t.Errorf("expected a no-match error, got %v", err)
+	}
+
+	suite = batchSuite(t, map[string]string{"inputs/x.json": `{}`}, "inputs/*.json")
+
+	err = parser.expandBatchTests(&suite)
+	if err == nil || !strings.Contains(err.Error(), "no expectation found") {
+		t.Errorf("expected an error for an input without expectations, got %v", err)
+	}
+}
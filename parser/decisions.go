@@ -0,0 +1,118 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+/*
+ *  A test can list several decisions, each with its own expectations, that all
+ *  share the test's input and JWT:
+ *
+ *    decisions:
+ *      - decision-path: /v1/data/app/allow
+ *        expects: { allowed: true }
+ *      - name: reasons
+ *        decision-path: /v1/data/audit/reasons
+ *        expects: [ substring: '"result":[]' ]
+ *
+ *  Every decision becomes a test of its own, named "test: decision", and the report
+ *  groups them back together by the test they came from.
+ */
+
+import (
+	"fmt"
+	"raygun/types"
+	"strings"
+)
+
+func (parser *RaygunParser) parseDecisions(test *types.TestRecord) error {
+
+	if len(test.Decisions) == 0 {
+		return nil
+	}
+
+	if len(test.Scenario) > 0 {
+		return fmt.Errorf("test %s: a test can have decisions or a scenario, not both", test.Name)
+	}
+
+	if test.DecisionPath != "" || test.Query != "" || len(test.ExpectData) > 0 {
+		return fmt.Errorf("test %s: with decisions, the decision-path and expects go under each decision", test.Name)
+	}
+
+	names := make(map[string]bool)
+
+	for i := range test.Decisions {
+
+		decision := &test.Decisions[i]
+
+		if decision.DecisionPath == "" {
+			return fmt.Errorf("test %s: decision %d has no decision-path", test.Name, i+1)
+		}
+
+		if decision.Name == "" {
+			decision.Name = strings.TrimPrefix(strings.TrimPrefix(decision.DecisionPath, "/v1/data"), "/")
+		}
+
+		if names[decision.Name] {
+			return fmt.Errorf("test %s: more than one decision is named %s", test.Name, decision.Name)
+		}
+		names[decision.Name] = true
+
+		// the expectations parse into a test record, so borrow one
+		expectations := types.TestRecord{ExpectsObj: decision.ExpectsObj}
+
+		err := parser.parseTestExpectations(&expectations)
+		if err != nil {
+			return fmt.Errorf("test %s, %s: %w", test.Name, decision.Name, err)
+		}
+
+		if len(expectations.ExpectData) == 0 {
+			return fmt.Errorf("test %s, %s: a decision needs expects", test.Name, decision.Name)
+		}
+
+		decision.ExpectData = expectations.ExpectData
+	}
+
+	return nil
+}
+
+/*
+ *  One test per decision. This runs after the json-glob expansion, so each input
+ *  file gets its own group
+ */
+func expandDecisionTests(suite *types.TestSuite) error {
+
+	expanded := make([]types.TestRecord, 0, len(suite.Tests))
+	groups := 0
+
+	for _, test := range suite.Tests {
+
+		if len(test.Decisions) == 0 {
+			expanded = append(expanded, test)
+			continue
+		}
+
+		groups++
+
+		for _, decision := range test.Decisions {
+
+			decision_test := test
+			decision_test.Name = fmt.Sprintf("%s: %s", test.Name, decision.Name)
+			decision_test.Group = test.Name
+			decision_test.GroupId = groups
+			decision_test.DecisionPath = decision.DecisionPath
+			decision_test.ExpectData = decision.ExpectData
+			decision_test.Decisions = nil
+
+			err := parseRequest(&decision_test)
+			if err != nil {
+				return err
+			}
+
+			expanded = append(expanded, decision_test)
+		}
+	}
+
+	suite.Tests = expanded
+
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const decisionSuite = `
+name: decisions
+tests:
+  - name: alice
+    input:
+      type: inline
+      value: '{"user": "alice"}'
+    decisions:
+      - decision-path: /v1/data/app/allow
+        expects:
+          allowed: true
+      - name: reasons
+        decision-path: /v1/data/audit/reasons
+        expects:
+          substring: '"result":[]'
+  - name: alice
+    input:
+      type: inline
+      value: '{"user": "alice", "admin": true}'
+    decisions:
+      - decision-path: /v1/data/app/allow
+        expects:
+          allowed: true
+  - name: plain
+    decision-path: /v1/data/app/allow
+    expects:
+      allowed: false
+    input:
+      type: inline
+      value: '{}'
+`
+
+func TestExpandDecisionTests(t *testing.T) {
+
+	suite, err := parseSuite(t, decisionSuite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = expandDecisionTests(&suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		name          string
+		decision_path string
+		group         string
+		group_id      int
+		expects       string
+	}{
+		{"alice: app/allow", "/v1/data/app/allow", "alice", 1, "allowed"},
+		{"alice: reasons", "/v1/data/audit/reasons", "alice", 1, "substring"},
+		{"alice: app/allow", "/v1/data/app/allow", "alice", 2, "allowed"},
+		{"plain", "/v1/data/app/allow", "", 0, "allowed"},
+	}
+
+	if len(suite.Tests) != len(expected) {
+		t.Fatalf("expected %d tests, got %d", len(expected), len(suite.Tests))
+	}
+
+	for i, e := range expected {
+
+		test := suite.Tests[i]
+
+		if test.Name != e.name || test.DecisionPath != e.decision_path || test.Group != e.group || test.GroupId != e.group_id {
+			t.Errorf("test %d: expected %s %s (group %q, %d), got %s %s (group %q, %d)", i, e.name, e.decision_path, e.group, e.group_id,
+				test.Name, test.DecisionPath, test.Group, test.GroupId)
+		}
+
+		if len(test.ExpectData) != 1 || test.ExpectData[0].ExpectationType != e.expects {
+			t.Errorf("test %d: expected a %s expectation, got %v", i, e.expects, test.ExpectData)
+		}
+
+		if len(test.Decisions) != 0 {
+			t.Errorf("test %d: expected the decisions to be expanded, got %v", i, test.Decisions)
+		}
+	}
+
+	// the input is shared by the group's decisions
+	if suite.Tests[0].Input.Value != suite.Tests[1].Input.Value || suite.Tests[0].Input.Value == suite.Tests[2].Input.Value {
+		t.Errorf("expected each group to keep its own input")
+	}
+}
+
+func TestParseDecisions_Errors(t *testing.T) {
+
+	cases := map[string]string{
+		"has no decision-path": `
+      - name: nowhere
+        expects:
+          allowed: true`,
+		"more than one decision is named": `
+      - decision-path: /v1/data/app/allow
+        expects:
+          allowed: true
+      - decision-path: /v1/data/app/allow
+        expects:
+          allowed: false`,
+		"a decision needs expects": `
+      - decision-path: /v1/data/app/allow`,
+	}
+
+	for message, decisions := range cases {
+
+		_, err := parseSuite(t, `
+tests:
+  - name: broken
+    input:
+      type: inline
+      value: '{}'
+    decisions:`+decisions)
+
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected an error with %q, got %v", message, err)
+		}
+	}
+}
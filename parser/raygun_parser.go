@@ -13,6 +13,7 @@ package parser
  */
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -21,6 +22,7 @@ import (
 	"raygun/log"
 	"raygun/types"
 	"raygun/util"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -56,7 +58,16 @@ func (parser *RaygunParser) Parse(raygun_file_list []string) ([]types.TestSuite,
 
 		suite := CreateEmptySuite(raygun_filename)
 
-		err = yaml.Unmarshal(data, &suite)
+		// decode without the default bundle-path, so we can tell if the suite has one
+		default_bundle_path := suite.Opa.BundlePath
+		suite.Opa.BundlePath = ""
+
+		err = decodeSuite(data, &suite)
+
+		if suite.Opa.BundlePath == "" {
+			suite.Opa.BundlePath = default_bundle_path
+			suite.Opa.DefaultBundle = true
+		}
 
 		var skip bool = false
 
@@ -74,6 +85,22 @@ func (parser *RaygunParser) Parse(raygun_file_list []string) ([]types.TestSuite,
 
 		err = parser.parseExpectations(&suite)
 
+		if err == nil {
+			err = parseSigning(&suite)
+		}
+
+		if err == nil {
+			err = expandAdmissionTests(&suite)
+		}
+
+		if err == nil {
+			err = parser.expandBatchTests(&suite)
+		}
+
+		if err == nil {
+			err = expandDecisionTests(&suite)
+		}
+
 		if err != nil {
 			if !parser.SkipOnParseError {
 				log.Fatal("Parse error on suite file: %s [%v]", raygun_filename, err)
@@ -102,25 +129,117 @@ func (parser *RaygunParser) parseExpectations(suite *types.TestSuite) error {
 		//
 		// but instead, it appeared to make a copy, so the parsing data was lost
 
-		if util.IsArray(suite.Tests[i].ExpectsObj) {
+		err := parser.parseTestExpectations(&suite.Tests[i])
+		if err != nil {
+			return err
+		}
 
-			expects_array := suite.Tests[i].ExpectsObj.([]interface{})
+		err = parser.parseExpectsAfter(suite, &suite.Tests[i])
+		if err != nil {
+			return err
+		}
 
-			for _, expects_map := range expects_array {
-				err := parser.yamlToExpectationsMap(&suite.Tests[i], expects_map.(map[string]interface{}))
-				if err != nil {
-					return err
-				}
-			}
+		err = checkReloadTest(suite, &suite.Tests[i])
+		if err != nil {
+			return err
+		}
+
+		err = parser.parseScenario(&suite.Tests[i])
+		if err != nil {
+			return err
+		}
+
+		err = parser.parseDecisions(&suite.Tests[i])
+		if err != nil {
+			return err
+		}
 
-		} else if util.IsMap(suite.Tests[i].ExpectsObj) {
-			expects_map := suite.Tests[i].ExpectsObj.(map[string]interface{})
-			err := parser.yamlToExpectationsMap(&suite.Tests[i], expects_map)
+		err = parseRequest(&suite.Tests[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (parser *RaygunParser) parseTestExpectations(test *types.TestRecord) error {
+
+	if util.IsArray(test.ExpectsObj) {
+
+		expects_array := test.ExpectsObj.([]interface{})
+
+		for _, expects_map := range expects_array {
+			err := parser.yamlToExpectationsMap(test, expects_map.(map[string]interface{}))
 			if err != nil {
 				return err
 			}
 		}
 
+	} else if util.IsMap(test.ExpectsObj) {
+		expects_map := test.ExpectsObj.(map[string]interface{})
+		err := parser.yamlToExpectationsMap(test, expects_map)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  expects-after: what a reload test expects once the new bundle is active, when
+ *  that isn't what it expects before the swap
+ */
+func (parser *RaygunParser) parseExpectsAfter(suite *types.TestSuite, test *types.TestRecord) error {
+
+	if test.ExpectsAfterObj == nil {
+		return nil
+	}
+
+	if suite.Reload == nil {
+		return fmt.Errorf("test %s: expects-after is only for suites with a reload section", test.Name)
+	}
+
+	if len(test.Decisions) > 0 || len(test.Scenario) > 0 {
+		return fmt.Errorf("test %s: expects-after can't be used with decisions or a scenario", test.Name)
+	}
+
+	// the expectations parse into a test record, so borrow one
+	expectations := types.TestRecord{ExpectsObj: test.ExpectsAfterObj}
+
+	err := parser.parseTestExpectations(&expectations)
+	if err != nil {
+		return fmt.Errorf("test %s, expects-after: %w", test.Name, err)
+	}
+
+	if len(expectations.ExpectData) == 0 {
+		return fmt.Errorf("test %s: expects-after has no expectations", test.Name)
+	}
+
+	test.ExpectAfterData = expectations.ExpectData
+
+	return nil
+}
+
+/*
+ *  A reload sends each test over and over as a single request, so anything that
+ *  needs more than that (its own fixtures, the steps of a scenario, several
+ *  decisions) can't be part of a reload suite
+ */
+func checkReloadTest(suite *types.TestSuite, test *types.TestRecord) error {
+
+	if suite.Reload == nil {
+		return nil
+	}
+
+	switch {
+	case len(test.Data) > 0:
+		return fmt.Errorf("test %s: a reload suite can't have tests with their own data fixtures, put them on the suite instead", test.Name)
+	case len(test.Scenario) > 0:
+		return fmt.Errorf("test %s: a reload suite can't have scenario tests", test.Name)
+	case len(test.Decisions) > 0:
+		return fmt.Errorf("test %s: a reload suite can't have tests with decisions", test.Name)
 	}
 
 	return nil
@@ -396,6 +515,48 @@ func (p RaygunParser) yamlToExpectationsMap(test *types.TestRecord, expectations
 			} else {
 				return fmt.Errorf("invalid substring value: %v, expecting string", v)
 			}
+		case "message":
+			if util.IsString(v) {
+
+				test.ExpectData[len(test.ExpectData)-1].ExpectationType = "message"
+				test.ExpectData[len(test.ExpectData)-1].Target = v.(string)
+
+			} else {
+				return fmt.Errorf("invalid message value: %v, expecting string", v)
+			}
+		case "residual":
+			// true, false or conditional
+			residual := strings.ToLower(fmt.Sprintf("%v", v))
+			if residual != "true" && residual != "false" && residual != "conditional" {
+				return fmt.Errorf("invalid residual value: %v, expecting true, false or conditional", v)
+			}
+
+			test.ExpectData[len(test.ExpectData)-1].ExpectationType = k
+			test.ExpectData[len(test.ExpectData)-1].Target = residual
+		case "references":
+			// a list of references, or a comma-separated string of them
+			references := make([]string, 0)
+			if util.IsString(v) {
+				references = append(references, v.(string))
+			} else if util.IsArray(v) {
+				for _, reference := range v.([]interface{}) {
+					references = append(references, fmt.Sprintf("%v", reference))
+				}
+			} else {
+				return fmt.Errorf("invalid references value: %v, expecting a list of references", v)
+			}
+
+			test.ExpectData[len(test.ExpectData)-1].ExpectationType = k
+			test.ExpectData[len(test.ExpectData)-1].Target = strings.Join(references, ",")
+		case "allowed", "http_status", "headers", "response_headers_to_add", "patch":
+			// these assertions compare a JSON value, so we keep the target as JSON
+			target, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s value: %v", k, v)
+			}
+
+			test.ExpectData[len(test.ExpectData)-1].ExpectationType = k
+			test.ExpectData[len(test.ExpectData)-1].Target = string(target)
 		default:
 			return fmt.Errorf("unknown/unsupported 'expects' section key: %s", k)
 		}
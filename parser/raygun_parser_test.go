@@ -0,0 +1,112 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"raygun/opa"
+	"raygun/types"
+	"strings"
+	"testing"
+)
+
+const reloadSuite = `
+name: rollover
+reload:
+  bundle-path: v2.tar.gz
+tests:
+  - name: tightened
+    decision-path: /v1/data/app/allow
+    expects:
+      allowed: true
+    expects-after:
+      allowed: false
+    input:
+      type: inline
+      value: '{}'
+`
+
+func parseSuite(t *testing.T, yaml string) (types.TestSuite, error) {
+
+	var suite types.TestSuite
+
+	err := decodeSuite([]byte(yaml), &suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return suite, NewRaygunParser(false).parseExpectations(&suite)
+}
+
+func TestParseExpectsAfter(t *testing.T) {
+
+	suite, err := parseSuite(t, reloadSuite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := suite.Tests[0]
+
+	if len(test.ExpectData) != 1 || test.ExpectData[0].Target != "true" {
+		t.Errorf("expected the test's own expectations to be left alone, got %v", test.ExpectData)
+	}
+
+	if len(test.ExpectAfterData) != 1 || test.ExpectAfterData[0].ExpectationType != "allowed" || test.ExpectAfterData[0].Target != "false" {
+		t.Errorf("expected allowed: false after the swap, got %v", test.ExpectAfterData)
+	}
+}
+
+func TestParseExpectsAfter_NeedsReload(t *testing.T) {
+
+	_, err := parseSuite(t, strings.Replace(reloadSuite, "reload:\n  bundle-path: v2.tar.gz\n", "", 1))
+
+	if err == nil || !strings.Contains(err.Error(), "reload") {
+		t.Errorf("expected expects-after without a reload section to be an error, got %v", err)
+	}
+}
+
+func TestCheckReloadTest_Scenario(t *testing.T) {
+
+	scenario := strings.Replace(reloadSuite, `    expects-after:
+      allowed: false
+`, `    scenario:
+      - name: first
+        decision-path: /v1/data/app/allow
+        expects:
+          allowed: true
+`, 1)
+
+	_, err := parseSuite(t, scenario)
+
+	if err == nil || !strings.Contains(err.Error(), "can't have scenario tests") {
+		t.Errorf("expected a scenario test in a reload suite to be an error, got %v", err)
+	}
+}
+
+func TestParseSigning(t *testing.T) {
+
+	directory := t.TempDir()
+	os.WriteFile(filepath.Join(directory, "public.pem"), []byte("-----BEGIN PUBLIC KEY-----\n"), 0644)
+
+	suite := types.TestSuite{Directory: directory, Opa: opa.OpaConfig{Signing: &opa.SigningConfig{VerificationKey: "public.pem"}}}
+
+	if err := parseSigning(&suite); err != nil || suite.Opa.Signing.VerificationKey != filepath.Join(directory, "public.pem") {
+		t.Errorf("expected the key file to be relative to the suite, got %s (%v)", suite.Opa.Signing.VerificationKey, err)
+	}
+
+	// not a file, so an HMAC secret
+	suite.Opa.Signing.VerificationKey = "s3cret"
+
+	if err := parseSigning(&suite); err != nil || suite.Opa.Signing.VerificationKey != "s3cret" {
+		t.Errorf("expected the secret to be left alone, got %s (%v)", suite.Opa.Signing.VerificationKey, err)
+	}
+
+	suite.Opa.Signing.RejectionTests = true
+	suite.Opa.EndpointUrl = "http://opa.example.com:8181"
+
+	if err := parseSigning(&suite); err == nil || !strings.Contains(err.Error(), "endpoint-url") {
+		t.Errorf("expected rejection-tests with an endpoint-url to be an error, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+/*
+ *  method:, query: and unknowns: choose how a test talks to OPA, see runner/request.go
+ */
+
+import (
+	"fmt"
+	"net/http"
+	"raygun/types"
+	"strings"
+)
+
+func parseRequest(test *types.TestRecord) error {
+
+	test.Method = strings.ToUpper(strings.TrimSpace(test.Method))
+
+	switch test.Method {
+	case "", http.MethodPost, http.MethodGet:
+	default:
+		return fmt.Errorf("test %s: unsupported method %s, expecting GET or POST", test.Name, test.Method)
+	}
+
+	path, _, _ := strings.Cut(test.DecisionPath, "?")
+
+	query := strings.TrimSpace(test.Query) != ""
+	compile := query && (len(test.Unknowns) > 0 || path == "/v1/compile")
+
+	if len(test.Unknowns) > 0 && !query {
+		return fmt.Errorf("test %s: unknowns are for partially evaluating a query, and there's no query", test.Name)
+	}
+
+	for _, expectation := range test.ExpectData {
+		if !compile && (expectation.ExpectationType == "residual" || expectation.ExpectationType == "references") {
+			return fmt.Errorf("test %s: %s expectations need a query with unknowns", test.Name, expectation.ExpectationType)
+		}
+	}
+
+	if query {
+
+		if test.Method == http.MethodGet {
+			return fmt.Errorf("test %s: a query is always POSTed, it can't use method: GET", test.Name)
+		}
+
+		if path != "" && path != "/v1/query" && path != "/v1/compile" {
+			return fmt.Errorf("test %s: a query is sent to /v1/query or /v1/compile, not %s", test.Name, test.DecisionPath)
+		}
+	}
+
+	if test.Method == http.MethodGet && (path == "" || path == "/") {
+		return fmt.Errorf("test %s: method: GET needs the decision-path of a data document", test.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+/*
+ *  scenario: tests are a list of steps. Each step's expects: is parsed like a
+ *  test's, and its capture: can be written a few ways:
+ *
+ *    capture: token = $.result.session_id
+ *    capture: [token = $.result.session_id, user = $.result.user]
+ *    capture: { token: $.result.session_id }
+ */
+
+import (
+	"fmt"
+	"raygun/types"
+	"raygun/util"
+	"strings"
+)
+
+func (parser *RaygunParser) parseScenario(test *types.TestRecord) error {
+
+	for i := range test.Scenario {
+
+		step := &test.Scenario[i]
+
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+
+		// the expectations parse into a test record, so borrow one
+		expectations := types.TestRecord{ExpectsObj: step.ExpectsObj}
+
+		err := parser.parseTestExpectations(&expectations)
+		if err != nil {
+			return fmt.Errorf("test %s, %s: %w", test.Name, step.Name, err)
+		}
+
+		step.ExpectData = expectations.ExpectData
+
+		step.Captures, err = parseCaptures(step.CaptureObj)
+		if err != nil {
+			return fmt.Errorf("test %s, %s: %w", test.Name, step.Name, err)
+		}
+
+		query := len(step.ExpectData) > 0 || len(step.Captures) > 0 || step.Input.InputType != "" || step.DecisionPath != ""
+
+		if query && step.DecisionPath == "" && test.DecisionPath == "" {
+			return fmt.Errorf("test %s, %s: a step with input, expects or capture needs a decision-path", test.Name, step.Name)
+		}
+
+		if !query && len(step.Data) == 0 {
+			return fmt.Errorf("test %s, %s: a step needs data to write, or a query (decision-path, input, expects or capture)", test.Name, step.Name)
+		}
+	}
+
+	return nil
+}
+
+func parseCaptures(capture_obj interface{}) ([]types.Capture, error) {
+
+	captures := make([]types.Capture, 0)
+
+	switch {
+	case capture_obj == nil:
+		return captures, nil
+
+	case util.IsString(capture_obj):
+		capture, err := parseCapture(capture_obj.(string))
+		if err != nil {
+			return nil, err
+		}
+		captures = append(captures, capture)
+
+	case util.IsArray(capture_obj):
+		for _, item := range capture_obj.([]interface{}) {
+			if !util.IsString(item) {
+				return nil, fmt.Errorf("invalid capture %v, expecting name = $.path", item)
+			}
+			capture, err := parseCapture(item.(string))
+			if err != nil {
+				return nil, err
+			}
+			captures = append(captures, capture)
+		}
+
+	case util.IsMap(capture_obj):
+		capture_map := capture_obj.(map[string]interface{})
+		for _, name := range util.SortMapKeys(capture_map) {
+			path, ok := capture_map[name].(string)
+			if !ok || !strings.HasPrefix(path, "$") {
+				return nil, fmt.Errorf("invalid capture %s: %v, expecting a JSONPath", name, capture_map[name])
+			}
+			captures = append(captures, types.Capture{Name: name, Path: path})
+		}
+
+	default:
+		return nil, fmt.Errorf("invalid capture %v, expecting name = $.path", capture_obj)
+	}
+
+	return captures, nil
+}
+
+func parseCapture(text string) (types.Capture, error) {
+
+	name, path, found := strings.Cut(text, "=")
+
+	name = strings.TrimSpace(name)
+	path = strings.TrimSpace(path)
+
+	if !found || name == "" || !strings.HasPrefix(path, "$") {
+		return types.Capture{}, fmt.Errorf("invalid capture %q, expecting name = $.path", text)
+	}
+
+	return types.Capture{Name: name, Path: path}, nil
+}
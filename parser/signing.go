@@ -0,0 +1,40 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+import (
+	"fmt"
+	"os"
+	"raygun/types"
+	"strings"
+)
+
+/*
+ *  A verification-key that names a file is relative to the suite's directory, like
+ *  every other file the suite refers to (PEM text and HMAC secrets are left alone).
+ *  The rejection tests start OPAs of their own, so they can't use an endpoint-url
+ */
+func parseSigning(suite *types.TestSuite) error {
+
+	signing := suite.Opa.Signing
+
+	if signing == nil {
+		return nil
+	}
+
+	if signing.RejectionTests && suite.Opa.EndpointUrl != "" {
+		return fmt.Errorf("signing: rejection-tests need an OPA that raygun starts, not the one at endpoint-url %s", suite.Opa.EndpointUrl)
+	}
+
+	if signing.VerificationKey != "" && !strings.Contains(signing.VerificationKey, "-----BEGIN") {
+
+		key_file := suiteRelative(*suite, signing.VerificationKey)
+
+		if info, err := os.Stat(key_file); err == nil && !info.IsDir() {
+			signing.VerificationKey = key_file
+		}
+	}
+
+	return nil
+}
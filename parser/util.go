@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"raygun/config"
 	"raygun/types"
+
+	"gopkg.in/yaml.v3"
 )
 
 func CreateEmptySuite(source string) types.TestSuite {
@@ -20,6 +22,113 @@ func CreateEmptySuite(source string) types.TestSuite {
 	suite.Opa.LogPath = config.OpaLogPath
 	suite.Opa.BundleUrl = config.OpaBundleUrl
 	suite.Opa.EndpointUrl = config.OpaEndpointUrl
+	suite.Opa.StartupTimeout = config.OpaStartupTimeout
+	suite.Opa.Engine = config.OpaEngine
+	suite.Opa.BundleServer = config.BundleServer
+	suite.Opa.PollingInterval = config.BundlePollingInterval
 
 	return suite
 }
+
+/*
+ *  A path from the suite file, relative to the suite's directory unless it's absolute
+ */
+func suiteRelative(suite types.TestSuite, path string) string {
+
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(suite.Directory, path)
+}
+
+/*
+ *  Decode a .raygun document into the suite, expanding ${} properties in every
+ *  scalar value along the way. Expanding each field (rather than the raw text)
+ *  means a property value can't break the YAML structure, which matters for
+ *  multi-line values like PEM keys.
+ *
+ *  Tokens that can't be resolved yet (like ${RAYGUN_GENERATED_JWT}) are left
+ *  exactly as they are, so they can still be expanded when the test runs. Inputs
+ *  are expanded when the test runs, so only their file names are expanded here
+ */
+func decodeSuite(data []byte, suite *types.TestSuite) error {
+
+	var document yaml.Node
+
+	err := yaml.Unmarshal(data, &document)
+	if err != nil {
+		return err
+	}
+
+	if document.Kind == 0 {
+		// empty document, nothing to decode
+		return nil
+	}
+
+	if config.Resolver != nil {
+		expandNodeProperties(&document)
+	}
+
+	return document.Decode(suite)
+}
+
+func expandNodeProperties(node *yaml.Node) {
+
+	if node.Kind == yaml.ScalarNode {
+		expandScalar(node)
+		return
+	}
+
+	for i, child := range node.Content {
+
+		// leave the keys of a map alone, only the values are expanded
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+
+		if node.Kind == yaml.MappingNode && node.Content[i-1].Value == "input" && child.Kind == yaml.MappingNode {
+			expandInputReference(child)
+			continue
+		}
+
+		expandNodeProperties(child)
+	}
+}
+
+/*
+ *  The file (or glob) an input points at, but not the input itself
+ */
+func expandInputReference(input *yaml.Node) {
+
+	input_type := ""
+	var value *yaml.Node
+
+	for i := 0; i+1 < len(input.Content); i += 2 {
+		switch input.Content[i].Value {
+		case "type":
+			input_type = input.Content[i+1].Value
+		case "value":
+			value = input.Content[i+1]
+		}
+	}
+
+	if value != nil && value.Kind == yaml.ScalarNode && (input_type == "json-file" || input_type == "json-glob") {
+		expandScalar(value)
+	}
+}
+
+func expandScalar(node *yaml.Node) {
+
+	expanded := config.Resolver.ExpandKnownProperties(node.Value)
+
+	if expanded != node.Value {
+		node.Value = expanded
+
+		// let the decoder re-resolve the type of unquoted values, so
+		// port: ${OPA_PORT} still decodes as a number
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package parser
+
+import (
+	"raygun/config"
+	"raygun/types"
+	"testing"
+)
+
+const propertySuite = `
+name: ${TENANT} suite
+opa:
+  bundle-path: bundles/${TENANT}.tar.gz
+  port: ${OPA_PORT}
+tests:
+  - name: inline
+    decision-path: /v1/data/${TENANT}/allow
+    expects:
+      substring: "costs $$5 for $user ${UNKNOWN}"
+    input:
+      type: inline
+      value: '{"tenant": "${TENANT}"}'
+  - name: file
+    decision-path: /v1/data/${TENANT}/allow
+    expects:
+      substring: "${TENANT}"
+    input:
+      type: json-file
+      value: inputs/${TENANT}.json
+`
+
+func TestDecodeSuite_Properties(t *testing.T) {
+
+	previous := config.Resolver
+	defer func() { config.Resolver = previous }()
+
+	config.Resolver = config.NewPropertyResolver()
+	config.Resolver.AddProperty("TENANT", "acme")
+	config.Resolver.AddProperty("OPA_PORT", "8282")
+
+	var suite types.TestSuite
+
+	err := decodeSuite([]byte(propertySuite), &suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if suite.Name != "acme suite" || suite.Opa.BundlePath != "bundles/acme.tar.gz" || suite.Opa.OpaPort != 8282 {
+		t.Errorf("expected the suite settings to be expanded: %s, %s, %d", suite.Name, suite.Opa.BundlePath, suite.Opa.OpaPort)
+	}
+
+	inline, file := suite.Tests[0], suite.Tests[1]
+
+	if inline.DecisionPath != "/v1/data/acme/allow" {
+		t.Errorf("expected the decision path to be expanded: %s", inline.DecisionPath)
+	}
+
+	expects := inline.ExpectsObj.(map[string]interface{})
+	if expects["substring"] != "costs $$5 for $user ${UNKNOWN}" {
+		t.Errorf("unresolved tokens should be left exactly as written: %s", expects["substring"])
+	}
+
+	// expanded once, when the test runs
+	if inline.Input.Value != `{"tenant": "${TENANT}"}` {
+		t.Errorf("inline input should not be expanded while parsing: %s", inline.Input.Value)
+	}
+
+	if file.Input.Value != "inputs/acme.json" {
+		t.Errorf("expected the input file name to be expanded: %s", file.Input.Value)
+	}
+
+	if file.ExpectsObj.(map[string]interface{})["substring"] != "acme" {
+		t.Errorf("expected the expectation target to be expanded: %v", file.ExpectsObj)
+	}
+}
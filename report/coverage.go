@@ -0,0 +1,78 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package report
+
+/*
+ *  With --coverage, each suite's result carries the line coverage of its bundle.
+ *  The text report shows it per file and rule, the JSON report has it as it is,
+ *  and --coverage-lcov writes an lcov tracefile for the usual coverage tools
+ */
+
+import (
+	"fmt"
+	"os"
+	"raygun/opa"
+	"raygun/types"
+	"strings"
+)
+
+func coverageText(results types.CombinedResult) string {
+
+	var sb strings.Builder
+
+	for _, suite_result := range results.ResultList {
+
+		report := suite_result.Coverage
+		if report == nil {
+			continue
+		}
+
+		sb.WriteString(fmt.Sprintf("   Suite: %s: %s\n", suite_result.Source.Name, lineSummary(report.Coverage, report.CoveredLines, report.NotCoveredLines)))
+
+		for _, file := range report.Files {
+
+			sb.WriteString(fmt.Sprintf("      %s: %s", file.File, lineSummary(file.Coverage, file.CoveredLines, file.NotCoveredLines)))
+			if len(file.NotCovered) > 0 {
+				sb.WriteString(fmt.Sprintf(", not covered: %s", opa.LineRanges(file.NotCovered)))
+			}
+			sb.WriteString("\n")
+
+			for _, rule := range file.Rules {
+
+				sb.WriteString(fmt.Sprintf("         %s (line %d): %5.1f%%", rule.Name, rule.Line, rule.Coverage))
+				if rule.CoveredLines == 0 {
+					sb.WriteString("  <- no test reaches it")
+				}
+				sb.WriteString("\n")
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+func lineSummary(coverage float64, covered int, not_covered int) string {
+	return fmt.Sprintf("%.1f%% (%d of %d lines)", coverage, covered, covered+not_covered)
+}
+
+/*
+ *  One record per suite and policy file, with the suite as the lcov test name
+ */
+func WriteLcov(results types.CombinedResult, path string) error {
+
+	var sb strings.Builder
+
+	for _, suite_result := range results.ResultList {
+		if suite_result.Coverage != nil {
+			sb.WriteString(suite_result.Coverage.Lcov(suite_result.Source.Name))
+		}
+	}
+
+	err := os.WriteFile(path, []byte(sb.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write the lcov report to %s: %w", path, err)
+	}
+
+	return nil
+}
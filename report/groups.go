@@ -0,0 +1,76 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package report
+
+/*
+ *  Tests with several decisions are run as one test per decision. The reports put
+ *  them back together, so the results for one input read as a group
+ */
+
+import (
+	"raygun/config"
+	"raygun/types"
+)
+
+type decisionGroup struct {
+	Name    string
+	Results []types.TestResult
+	Passed  int
+	Failed  int
+}
+
+/*
+ *  The groups in the order their tests appear in the suite. Test names needn't be
+ *  unique, so the results are matched up by group id and decision name
+ */
+func decisionGroups(suite_result types.TestSuiteResult) []decisionGroup {
+
+	type decisionKey struct {
+		group int
+		name  string
+	}
+
+	results := make(map[decisionKey]types.TestResult)
+
+	for _, list := range [][]types.TestResult{suite_result.Passed, suite_result.Failed, suite_result.Skipped} {
+		for _, result := range list {
+			if result.Source.GroupId != 0 {
+				results[decisionKey{result.Source.GroupId, result.Source.Name}] = result
+			}
+		}
+	}
+
+	groups := make([]decisionGroup, 0)
+	index := make(map[int]int)
+
+	for _, test := range suite_result.Source.Tests {
+
+		if test.GroupId == 0 {
+			continue
+		}
+
+		result, found := results[decisionKey{test.GroupId, test.Name}]
+		if !found {
+			continue
+		}
+
+		i, seen := index[test.GroupId]
+		if !seen {
+			i = len(groups)
+			index[test.GroupId] = i
+			groups = append(groups, decisionGroup{Name: test.Group})
+		}
+
+		groups[i].Results = append(groups[i].Results, result)
+
+		switch result.Status {
+		case config.PASS:
+			groups[i].Passed++
+		case config.FAIL:
+			groups[i].Failed++
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,51 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package report
+
+import (
+	"raygun/config"
+	"raygun/types"
+	"testing"
+)
+
+func TestDecisionGroups(t *testing.T) {
+
+	// two tests called alice, each with decisions, and one without
+	tests := []types.TestRecord{
+		{Name: "alice: allow", Group: "alice", GroupId: 1, DecisionPath: "/v1/data/app/allow"},
+		{Name: "alice: reasons", Group: "alice", GroupId: 1, DecisionPath: "/v1/data/audit/reasons"},
+		{Name: "plain", DecisionPath: "/v1/data/app/allow"},
+		{Name: "alice: allow", Group: "alice", GroupId: 2, DecisionPath: "/v1/data/app/allow"},
+	}
+
+	result := func(test types.TestRecord, status string) types.TestResult {
+		return types.TestResult{Source: test, Status: status}
+	}
+
+	suite_result := types.TestSuiteResult{
+		Source: types.TestSuite{Tests: tests},
+		Passed: []types.TestResult{result(tests[0], config.PASS), result(tests[2], config.PASS), result(tests[1], config.PASS)},
+		Failed: []types.TestResult{result(tests[3], config.FAIL)},
+	}
+
+	groups := decisionGroups(suite_result)
+
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %v", groups)
+	}
+
+	first, second := groups[0], groups[1]
+
+	if first.Name != "alice" || first.Passed != 2 || first.Failed != 0 || len(first.Results) != 2 {
+		t.Errorf("unexpected first group: %+v", first)
+	}
+
+	if first.Results[0].Source.DecisionPath != "/v1/data/app/allow" || first.Results[1].Source.DecisionPath != "/v1/data/audit/reasons" {
+		t.Errorf("expected the first group's decisions in suite order, got %v", first.Results)
+	}
+
+	if second.Name != "alice" || second.Passed != 0 || second.Failed != 1 || len(second.Results) != 1 {
+		t.Errorf("expected the second alice to be a group of its own, got %+v", second)
+	}
+}
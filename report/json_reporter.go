@@ -48,6 +48,18 @@ func generate_aggregate_suite_reports(list []types.TestSuiteResult) []interface{
 		suite_report["PASSED"] = generate_aggregate_test_reports(suite_result.Passed)
 		suite_report["FAILED"] = generate_aggregate_test_reports(suite_result.Failed)
 
+		if groups := generate_group_reports(suite_result); len(groups) > 0 {
+			suite_report["groups"] = groups
+		}
+
+		if suite, paths := suiteMetrics(suite_result); suite != nil {
+			suite_report["opa_metrics"] = map[string]interface{}{"suite": suite, "paths": paths}
+		}
+
+		if suite_result.Coverage != nil {
+			suite_report["coverage"] = suite_result.Coverage
+		}
+
 		aggregate_suite_report = append(aggregate_suite_report, suite_report)
 
 	}
@@ -64,6 +76,13 @@ func generate_aggregate_test_reports(test_list []types.TestResult) []interface{}
 
 		report["name"] = test_result.Source.Name
 		report["description"] = test_result.Source.Description
+		if test_result.Source.Group != "" {
+			report["group"] = test_result.Source.Group
+			report["decision_path"] = test_result.Source.DecisionPath
+		}
+		if test_result.Details != "" {
+			report["details"] = test_result.Details
+		}
 		if test_result.Status == config.FAIL {
 
 			var comparison_type_array []string = make([]string, 0)
@@ -79,10 +98,17 @@ func generate_aggregate_test_reports(test_list []types.TestResult) []interface{}
 			report["comparison_type"] = comparison_type_array
 			report["expected_value"] = expected_value_array
 
+			if test_result.Explanation != "" {
+				report["explanation"] = strings.Split(test_result.Explanation, "\n")
+			}
+
 		}
 		if config.PerformanceMetrics {
 			report["durationMicroseconds"] = test_result.Duration.Microseconds()
 		}
+		if len(test_result.Metrics) > 0 {
+			report["opa_metrics"] = test_result.Metrics
+		}
 
 		aggregate_test_report = append(aggregate_test_report, report)
 	}
@@ -90,3 +116,33 @@ func generate_aggregate_test_reports(test_list []types.TestResult) []interface{}
 	return aggregate_test_report
 
 }
+
+/*
+ *  the tests with several decisions, with each decision's status
+ */
+func generate_group_reports(suite_result types.TestSuiteResult) []interface{} {
+
+	group_reports := make([]interface{}, 0)
+
+	for _, group := range decisionGroups(suite_result) {
+
+		decisions := make([]interface{}, 0, len(group.Results))
+
+		for _, test_result := range group.Results {
+			decisions = append(decisions, map[string]interface{}{
+				"name":          test_result.Source.Name,
+				"decision_path": test_result.Source.DecisionPath,
+				"status":        test_result.Status,
+			})
+		}
+
+		group_reports = append(group_reports, map[string]interface{}{
+			"name":      group.Name,
+			"passed":    group.Passed,
+			"failed":    group.Failed,
+			"decisions": decisions,
+		})
+	}
+
+	return group_reports
+}
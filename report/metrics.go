@@ -0,0 +1,121 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package report
+
+/*
+ *  With --opa-metrics, every result carries OPA's timers and counters for its
+ *  request. The reports add them up for the whole suite, and for each decision path
+ */
+
+import (
+	"fmt"
+	"raygun/types"
+	"sort"
+	"strings"
+)
+
+// the timers the text report shows. The JSON report has all of them
+var KEY_TIMERS = []string{"timer_server_handler_ns", "timer_rego_query_eval_ns", "timer_rego_query_compile_ns", "timer_rego_input_parse_ns"}
+
+type metricSummary struct {
+	Count int   `json:"count"`
+	Total int64 `json:"total"`
+	Max   int64 `json:"max"`
+	Mean  int64 `json:"mean"`
+}
+
+type metricGroup struct {
+	Name     string                    `json:"name"`
+	Requests int                       `json:"requests"`
+	Metrics  map[string]*metricSummary `json:"metrics"`
+}
+
+func (group *metricGroup) add(metrics map[string]int64) {
+
+	group.Requests++
+
+	for name, value := range metrics {
+
+		summary, found := group.Metrics[name]
+		if !found {
+			summary = &metricSummary{}
+			group.Metrics[name] = summary
+		}
+
+		summary.Count++
+		summary.Total += value
+		if value > summary.Max {
+			summary.Max = value
+		}
+		summary.Mean = summary.Total / int64(summary.Count)
+	}
+}
+
+/*
+ *  The suite's metrics as a whole, then each decision path's, in path order.
+ *  Nothing at all when none of the results have metrics
+ */
+func suiteMetrics(suite_result types.TestSuiteResult) (*metricGroup, []*metricGroup) {
+
+	suite := &metricGroup{Name: suite_result.Source.Name, Metrics: make(map[string]*metricSummary)}
+	paths := make(map[string]*metricGroup)
+
+	for _, list := range [][]types.TestResult{suite_result.Passed, suite_result.Failed} {
+		for _, result := range list {
+
+			if len(result.Metrics) == 0 {
+				continue
+			}
+
+			path := result.Source.DecisionPath
+			if result.Source.Query != "" {
+				path = result.Source.Query
+			}
+
+			group, found := paths[path]
+			if !found {
+				group = &metricGroup{Name: path, Metrics: make(map[string]*metricSummary)}
+				paths[path] = group
+			}
+
+			group.add(result.Metrics)
+			suite.add(result.Metrics)
+		}
+	}
+
+	if suite.Requests == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	groups := make([]*metricGroup, len(names))
+	for i, name := range names {
+		groups[i] = paths[name]
+	}
+
+	return suite, groups
+}
+
+/*
+ *  "rego_query_eval 120.5/230.7, ..." for the key timers, in microseconds
+ */
+func timerSummary(group *metricGroup) string {
+
+	parts := make([]string, 0, len(KEY_TIMERS))
+
+	for _, name := range KEY_TIMERS {
+		if summary, found := group.Metrics[name]; found {
+			short := strings.TrimSuffix(strings.TrimPrefix(name, "timer_"), "_ns")
+			parts = append(parts, fmt.Sprintf("%s %.1f/%.1f", short, float64(summary.Mean)/1000.0, float64(summary.Max)/1000.0))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -64,6 +64,9 @@ func (tr TextReporter) Generate(results types.CombinedResult) string {
 			sb.WriteString(fmt.Sprintf("      PASSED: %s\n", test_result.Source.Name))
 			if config.Verbose {
 				sb.WriteString(fmt.Sprintf("        - %s\n", test_result.Source.Description))
+				if test_result.Details != "" {
+					sb.WriteString(fmt.Sprintf("        - %s\n", test_result.Details))
+				}
 			}
 			if config.PerformanceMetrics {
 				sb.WriteString(fmt.Sprintf("        - Duration: %d Microseconds\n", test_result.Duration.Microseconds()))
@@ -83,6 +86,9 @@ func (tr TextReporter) Generate(results types.CombinedResult) string {
 			sb.WriteString(fmt.Sprintf("      FAILED: %s\n", test_result.Source.Name))
 			if config.Verbose {
 				sb.WriteString(fmt.Sprintf("        - %s\n", test_result.Source.Description))
+				if test_result.Details != "" {
+					sb.WriteString(fmt.Sprintf("        - %s\n", test_result.Details))
+				}
 			}
 			if config.PerformanceMetrics {
 				sb.WriteString(fmt.Sprintf("        - Duration: %d Microseconds\n", test_result.Duration.Microseconds()))
@@ -102,10 +108,26 @@ func (tr TextReporter) Generate(results types.CombinedResult) string {
 					sb.WriteString(fmt.Sprintf("        Input File: %s\n", test_result.Source.Input.Value))
 				}
 
+				if test_result.Explanation != "" {
+					sb.WriteString("        Explanation:\n")
+					for _, line := range strings.Split(test_result.Explanation, "\n") {
+						sb.WriteString(fmt.Sprintf("          %s\n", line))
+					}
+				}
+
 			}
 			sb.WriteString("\n")
 		}
 
+		if config.Verbose {
+			for _, group := range decisionGroups(suite_result) {
+				sb.WriteString(fmt.Sprintf("      DECISIONS: %s (%d passed, %d failed)\n", group.Name, group.Passed, group.Failed))
+				for _, test_result := range group.Results {
+					sb.WriteString(fmt.Sprintf("        - %s: %s\n", strings.ToUpper(test_result.Status), test_result.Source.DecisionPath))
+				}
+			}
+		}
+
 	}
 
 	if config.PerformanceMetrics {
@@ -117,6 +139,30 @@ func (tr TextReporter) Generate(results types.CombinedResult) string {
 		sb.WriteString(fmt.Sprintf("Average Milliseconds per (non-skipped) test:  %5.2f\n", (float64(totalTestDurationMicroS)/float64(passedCount+failureCount))/1000.0))
 	}
 
+	if config.OpaMetrics {
+		sb.WriteString("\n")
+		sb.WriteString("OPA Metrics (mean/max microseconds):\n")
+
+		for _, suite_result := range results.ResultList {
+
+			suite, paths := suiteMetrics(suite_result)
+			if suite == nil {
+				continue
+			}
+
+			sb.WriteString(fmt.Sprintf("   Suite: %s (%d requests): %s\n", suite.Name, suite.Requests, timerSummary(suite)))
+			for _, path := range paths {
+				sb.WriteString(fmt.Sprintf("      %s (%d requests): %s\n", path.Name, path.Requests, timerSummary(path)))
+			}
+		}
+	}
+
+	if config.Coverage {
+		sb.WriteString("\n")
+		sb.WriteString("Policy Coverage:\n")
+		sb.WriteString(coverageText(results))
+	}
+
 	sb.WriteString("\n")
 
 	if failureCount > 0 {
@@ -0,0 +1,292 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  Support for testing Kubernetes admission policies.
+ *
+ *  We wrap a manifest into the admission.k8s.io/v1 AdmissionReview that the API
+ *  server would send, and understand the common ways admission policies answer:
+ *  a full AdmissionReview response (kube-mgmt's system.main), an object with
+ *  allowed/status, or a set of deny messages.
+ */
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"raygun/types"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+func buildAdmissionReview(admission types.AdmissionRequest) (string, error) {
+
+	operation := strings.ToUpper(admission.Operation)
+	if operation == "" {
+		operation = "CREATE"
+	}
+
+	object := admission.Object
+	old_object := admission.OldObject
+
+	// the API server sends the object being deleted as the oldObject
+	if operation == "DELETE" && old_object == nil {
+		old_object = object
+		object = nil
+	}
+
+	// everything about the request kind comes from whichever object we have
+	subject := object
+	if subject == nil {
+		subject = old_object
+	}
+
+	group, version := splitApiVersion(fmt.Sprintf("%v", subject["apiVersion"]))
+	kind := fmt.Sprintf("%v", subject["kind"])
+
+	name := ""
+	namespace := admission.Namespace
+	if metadata, ok := subject["metadata"].(map[string]interface{}); ok {
+		if v, ok := metadata["name"].(string); ok {
+			name = v
+		}
+		if v, ok := metadata["namespace"].(string); ok && namespace == "" {
+			namespace = v
+		}
+	}
+
+	group_version_kind := map[string]interface{}{"group": group, "version": version, "kind": kind}
+	group_version_resource := map[string]interface{}{"group": group, "version": version, "resource": pluralize(kind)}
+
+	user_info := map[string]interface{}{"username": admission.User.Username}
+	if admission.User.Uid != "" {
+		user_info["uid"] = admission.User.Uid
+	}
+	if len(admission.User.Groups) > 0 {
+		user_info["groups"] = admission.User.Groups
+	}
+
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("unable to generate an admission request uid: %w", err)
+	}
+
+	request := map[string]interface{}{
+		"uid":             uid.String(),
+		"kind":            group_version_kind,
+		"resource":        group_version_resource,
+		"requestKind":     group_version_kind,
+		"requestResource": group_version_resource,
+		"name":            name,
+		"namespace":       namespace,
+		"operation":       operation,
+		"userInfo":        user_info,
+		"object":          object,
+		"oldObject":       old_object,
+		"dryRun":          admission.DryRun,
+	}
+
+	review := map[string]interface{}{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind":       "AdmissionReview",
+		"request":    request,
+	}
+
+	b, err := json.Marshal(review)
+	if err != nil {
+		return "", fmt.Errorf("unable to build AdmissionReview: %w", err)
+	}
+
+	return string(b), nil
+}
+
+/*
+ *  apps/v1 -> (apps, v1), v1 -> ("", v1) for the core group
+ */
+func splitApiVersion(api_version string) (string, string) {
+
+	if group, version, found := strings.Cut(api_version, "/"); found {
+		return group, version
+	}
+
+	return "", api_version
+}
+
+/*
+ *  The resource name of a kind, following the usual Kubernetes conventions. This
+ *  isn't the API server's RESTMapper, but it's right for the built-in kinds
+ */
+func pluralize(kind string) string {
+
+	resource := strings.ToLower(kind)
+
+	switch {
+	case resource == "" || resource == "endpoints":
+		return resource
+	case strings.HasSuffix(resource, "s"), strings.HasSuffix(resource, "x"), strings.HasSuffix(resource, "ch"):
+		return resource + "es"
+	case len(resource) > 1 && strings.HasSuffix(resource, "y") && !strings.ContainsAny(resource[len(resource)-2:len(resource)-1], "aeiou"):
+		return resource[:len(resource)-1] + "ies"
+	default:
+		return resource + "s"
+	}
+}
+
+/*
+ *  The admission assertions that aren't shared with the other decision types
+ */
+func evaluateAdmissionExpectation(expected types.TestExpectation, result interface{}) (bool, error) {
+
+	switch expected.ExpectationType {
+	case "message":
+		for _, message := range admissionMessages(result) {
+			if strings.Contains(message, expected.Target) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+
+	case "patch":
+		var want []map[string]interface{}
+		if err := json.Unmarshal([]byte(expected.Target), &want); err != nil {
+
+			// a single operation doesn't need to be wrapped in a list
+			var operation map[string]interface{}
+			if err := json.Unmarshal([]byte(expected.Target), &operation); err != nil {
+				return false, fmt.Errorf("patch expectation must be a list of JSON patch operations: %s", expected.Target)
+			}
+
+			want = append(want, operation)
+		}
+
+		patches, err := admissionPatches(result)
+		if err != nil {
+			return false, nil
+		}
+
+		// every expected operation must appear in the returned patch
+		for _, operation := range want {
+			if !containsPatchOperation(patches, operation) {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}
+
+	return false, fmt.Errorf("unsupported admission expectation: %s", expected.ExpectationType)
+}
+
+/*
+ *  The response part of the decision - either the response of a full
+ *  AdmissionReview, or the decision itself
+ */
+func admissionResponse(result interface{}) map[string]interface{} {
+
+	decision, ok := result.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if response, ok := decision["response"].(map[string]interface{}); ok {
+		return response
+	}
+
+	return decision
+}
+
+func admissionMessages(result interface{}) []string {
+
+	messages := make([]string, 0)
+
+	// a bare set of deny messages, when the decision path is the deny rule itself
+	if deny, ok := result.([]interface{}); ok {
+		for _, message := range deny {
+			messages = append(messages, fmt.Sprintf("%v", message))
+		}
+		return messages
+	}
+
+	response := admissionResponse(result)
+	if response == nil {
+		return messages
+	}
+
+	if status, ok := response["status"].(map[string]interface{}); ok {
+		if message, ok := status["message"].(string); ok {
+			messages = append(messages, message)
+		}
+	}
+
+	if message, ok := response["message"].(string); ok {
+		messages = append(messages, message)
+	}
+
+	if deny, ok := response["deny"].([]interface{}); ok {
+		for _, message := range deny {
+			messages = append(messages, fmt.Sprintf("%v", message))
+		}
+	}
+
+	return messages
+}
+
+/*
+ *  Mutating policies return a JSON patch, base64 encoded in a real AdmissionReview
+ *  response, or sometimes as a plain list of operations
+ */
+func admissionPatches(result interface{}) ([]interface{}, error) {
+
+	response := admissionResponse(result)
+	if response == nil {
+		return nil, fmt.Errorf("no admission response found")
+	}
+
+	switch patch := response["patch"].(type) {
+	case []interface{}:
+		return patch, nil
+	case string:
+		decoded, err := base64.StdEncoding.DecodeString(patch)
+		if err != nil {
+			return nil, err
+		}
+
+		var operations []interface{}
+		err = json.Unmarshal(decoded, &operations)
+
+		return operations, err
+	}
+
+	return nil, fmt.Errorf("no patch found")
+}
+
+func containsPatchOperation(patches []interface{}, want map[string]interface{}) bool {
+
+	for _, element := range patches {
+
+		operation, ok := element.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		matched := true
+		for k, v := range want {
+			expected, _ := json.Marshal(v)
+			actual, _ := json.Marshal(operation[k])
+			if string(expected) != string(actual) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
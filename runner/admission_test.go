@@ -0,0 +1,89 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"raygun/types"
+	"testing"
+)
+
+func TestAdmissionReview_Delete(t *testing.T) {
+
+	deployment := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "shop"},
+	}
+
+	review, err := buildAdmissionReview(types.AdmissionRequest{Operation: "delete", Object: deployment})
+	if err != nil {
+		t.Fatalf("unable to build AdmissionReview: %v", err)
+	}
+
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(review), &doc)
+
+	request := doc["request"].(map[string]interface{})
+
+	if request["object"] != nil || request["oldObject"] == nil {
+		t.Errorf("expected a DELETE to send the manifest as the oldObject, got: %s", review)
+	}
+
+	resource, _ := json.Marshal(request["resource"])
+	if string(resource) != `{"group":"apps","resource":"deployments","version":"v1"}` {
+		t.Errorf("unexpected resource: %s", resource)
+	}
+
+	if request["namespace"] != "shop" || request["name"] != "web" {
+		t.Errorf("expected name and namespace from the manifest, got: %v %v", request["name"], request["namespace"])
+	}
+}
+
+func TestAdmissionExpectation_Response(t *testing.T) {
+
+	patch := base64.StdEncoding.EncodeToString([]byte(`[{"op":"add","path":"/metadata/labels/team","value":"shop"}]`))
+
+	decision, _ := decisionResult(fmt.Sprintf(`{"result":{"kind":"AdmissionReview","response":{"allowed":false,"status":{"message":"images must come from the internal registry"},"patch":"%s"}}}`, patch))
+
+	expectations := []types.TestExpectation{
+		{ExpectationType: "allowed", Target: "false"},
+		{ExpectationType: "message", Target: "internal registry"},
+		{ExpectationType: "patch", Target: `{"op":"add","path":"/metadata/labels/team"}`},
+	}
+
+	for _, expected := range expectations {
+		passed, err := evaluateDecisionExpectation(expected, decision)
+		if err != nil || !passed {
+			t.Errorf("expected %v to pass, got: %v %v", expected, passed, err)
+		}
+	}
+
+	deny, _ := decisionResult(`{"result":{"deny":[]}}`)
+	if allowed, found := decisionAllowed(deny); !found || !allowed {
+		t.Errorf("expected an empty deny set to be allowed")
+	}
+}
+
+func TestAdmissionExpectation_BareDenySet(t *testing.T) {
+
+	deny, _ := decisionResult(`{"result":["privileged containers are not allowed"]}`)
+
+	if allowed, found := decisionAllowed(deny); !found || allowed {
+		t.Errorf("expected a non-empty deny set to be denied")
+	}
+
+	passed, err := evaluateDecisionExpectation(types.TestExpectation{ExpectationType: "message", Target: "privileged"}, deny)
+	if err != nil || !passed {
+		t.Errorf("expected the message to be found in the deny set, got: %v %v", passed, err)
+	}
+
+	empty, _ := decisionResult(`{"result":[]}`)
+
+	if allowed, found := decisionAllowed(empty); !found || !allowed {
+		t.Errorf("expected an empty deny set to be allowed")
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  bundle-expects: checks on the suite's bundle, rather than its decisions. The
+ *  manifest roots and revision, metadata keys, data files and packages each become
+ *  a result of their own, so a wrong roots declaration fails a test like anything
+ *  else would
+ */
+
+import (
+	"fmt"
+	"raygun/config"
+	"raygun/opa"
+	"raygun/types"
+	"sort"
+	"strings"
+	"time"
+)
+
+const BUNDLE_SOURCE_FILE = "file"
+const BUNDLE_SOURCE_LIVE = "live"
+
+func (suiteRunner *SuiteRunner) checkBundleExpectations(suite types.TestSuite) []types.TestResult {
+
+	expects := suite.BundleExpects
+
+	start := time.Now()
+
+	source := bundleSource(suite)
+
+	var report opa.BundleReport
+	var err error
+
+	switch source {
+	case BUNDLE_SOURCE_FILE:
+		report, err = opa.InspectBundle(suite.Opa.BundlePath)
+	case BUNDLE_SOURCE_LIVE:
+		if suiteRunner.OpaRunner == nil || suite.Opa.InProcess() {
+			err = fmt.Errorf("there's no running OPA to ask")
+		} else {
+			report, err = suiteRunner.OpaRunner.InspectActiveBundle(expects.BundleName)
+		}
+	default:
+		err = fmt.Errorf("unknown bundle-expects source %s (expecting %s or %s)", source, BUNDLE_SOURCE_FILE, BUNDLE_SOURCE_LIVE)
+	}
+
+	newResult := func(name string) types.TestResult {
+		return types.TestResult{
+			Source: types.TestRecord{Suite: suite, Name: "bundle: " + name, Description: fmt.Sprintf("the %s bundle %s", source, report.Name)},
+			Start:  start,
+			End:    time.Now(),
+		}
+	}
+
+	if err != nil {
+		result := newResult("inspected")
+		result.Status = config.FAIL
+		result.Actual = err.Error()
+		result.Details = result.Actual
+		return []types.TestResult{result}
+	}
+
+	results := make([]types.TestResult, 0)
+
+	// the expectation is what the text report shows next to the actual value
+	check := func(name string, expected string, passed bool, actual string) {
+		result := newResult(name)
+		result.Source.ExpectData = []types.TestExpectation{{ExpectationType: "bundle " + name, Target: expected}}
+		result.Actual = actual
+		result.Status = config.FAIL
+		if passed {
+			result.Status = config.PASS
+		}
+		result.Duration = result.End.Sub(result.Start)
+		results = append(results, result)
+	}
+
+	if len(expects.Roots) > 0 {
+		check("roots", fmt.Sprintf("%v", expects.Roots), sameStrings(expects.Roots, report.Roots), fmt.Sprintf("%v", report.Roots))
+	}
+
+	if expects.Revision != "" {
+		check("revision", expects.Revision, expects.Revision == report.Revision, report.Revision)
+	}
+
+	for _, key := range expects.MetadataKeys {
+		value, found := metadataValue(report.Metadata, key)
+		actual := fmt.Sprintf("%v", value)
+		if !found {
+			actual = fmt.Sprintf("not in metadata %v", report.Metadata)
+		}
+		check("metadata "+key, "present", found, actual)
+	}
+
+	if len(expects.DataFiles) > 0 {
+		if report.DataFiles == nil {
+			check("data files", fmt.Sprintf("%v", expects.DataFiles), false, "OPA doesn't report a bundle's data files, use source: file")
+		} else {
+			expected := make([]string, len(expects.DataFiles))
+			for i, data_file := range expects.DataFiles {
+				expected[i] = strings.TrimPrefix(data_file, "/")
+			}
+			check("data files", fmt.Sprintf("%v", expected), sameStrings(expected, report.DataFiles), fmt.Sprintf("%v", report.DataFiles))
+		}
+	}
+
+	for _, package_path := range expects.Packages {
+		package_path = strings.TrimPrefix(package_path, "data.")
+		found := false
+		for _, existing := range report.Packages {
+			if existing == package_path {
+				found = true
+				break
+			}
+		}
+		check("package "+package_path, "included", found, fmt.Sprintf("packages %v", report.Packages))
+	}
+
+	return results
+}
+
+/*
+ *  Unless the suite says otherwise, we read the bundle file if there is one, and
+ *  ask OPA if there isn't (e.g. it loads the bundle from a bundle-url, or it's the
+ *  OPA at endpoint-url)
+ */
+func bundleSource(suite types.TestSuite) string {
+
+	if suite.BundleExpects.Source != "" {
+		return suite.BundleExpects.Source
+	}
+
+	if suite.Opa.DefaultBundle || strings.HasPrefix(suite.Opa.BundleUrl, "http://") || strings.HasPrefix(suite.Opa.BundleUrl, "https://") {
+		return BUNDLE_SOURCE_LIVE
+	}
+
+	return BUNDLE_SOURCE_FILE
+}
+
+func sameStrings(a []string, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	sorted_a := append([]string{}, a...)
+	sorted_b := append([]string{}, b...)
+
+	sort.Strings(sorted_a)
+	sort.Strings(sorted_b)
+
+	for i := range sorted_a {
+		if sorted_a[i] != sorted_b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+ *  Follow a dotted path (release.version) through the manifest metadata
+ */
+func metadataValue(metadata map[string]interface{}, key string) (interface{}, bool) {
+
+	var current interface{} = metadata
+
+	for _, segment := range strings.Split(key, ".") {
+
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = object[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
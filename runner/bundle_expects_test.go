@@ -0,0 +1,73 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"raygun/config"
+	"raygun/parser"
+	"raygun/types"
+	"testing"
+)
+
+func TestMetadataValue(t *testing.T) {
+
+	metadata := map[string]interface{}{"release": map[string]interface{}{"version": "1.2"}, "team": "payments"}
+
+	if value, found := metadataValue(metadata, "release.version"); !found || value != "1.2" {
+		t.Errorf("expected release.version, got %v %v", value, found)
+	}
+
+	if _, found := metadataValue(metadata, "team.name"); found {
+		t.Errorf("team isn't an object, so team.name shouldn't be found")
+	}
+
+	if _, found := metadataValue(nil, "team"); found {
+		t.Errorf("nothing should be found without metadata")
+	}
+}
+
+func TestCheckBundleExpectations_NoBundle(t *testing.T) {
+
+	suite := types.TestSuite{Name: "in-process", BundleExpects: &types.BundleExpectations{Source: BUNDLE_SOURCE_LIVE, Revision: "r1"}}
+	suite.Opa.Engine = "embedded"
+
+	suiteRunner := NewSuiteRunner(nil)
+
+	results := suiteRunner.checkBundleExpectations(suite)
+
+	if len(results) != 1 || results[0].Status != config.FAIL {
+		t.Errorf("expected a single failure when there's no OPA to ask, got %v", results)
+	}
+
+	if !sameStrings([]string{"b", "a"}, []string{"a", "b"}) || sameStrings([]string{"a"}, []string{"a", "a"}) {
+		t.Errorf("sameStrings should ignore order, and nothing else")
+	}
+}
+
+func TestBundleSource_NoBundlePath(t *testing.T) {
+
+	directory := t.TempDir()
+
+	suite_file := filepath.Join(directory, "remote.raygun")
+	os.WriteFile(suite_file, []byte("name: remote\nopa:\n  endpoint-url: http://opa.example.com:8181\nbundle-expects:\n  revision: r1\n"), 0644)
+
+	bundled_file := filepath.Join(directory, "bundled.raygun")
+	os.WriteFile(bundled_file, []byte("name: bundled\nopa:\n  bundle-path: bundle.tar.gz\nbundle-expects:\n  revision: r1\n"), 0644)
+
+	suites, err := parser.NewRaygunParser(false).Parse([]string{suite_file, bundled_file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the suite only has the default bundle-path, so the bundle has to come from OPA
+	if source := bundleSource(suites[0]); source != BUNDLE_SOURCE_LIVE {
+		t.Errorf("expected a suite without a bundle-path to ask OPA, got %s", source)
+	}
+
+	if source := bundleSource(suites[1]); source != BUNDLE_SOURCE_FILE {
+		t.Errorf("expected a suite with a bundle-path to read the file, got %s", source)
+	}
+}
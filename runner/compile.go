@@ -0,0 +1,64 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  Expectations on a partial evaluation (query: with unknowns:):
+ *
+ *    residual: true | false | conditional   whether the answer depends on the unknowns
+ *    references: [input.resource]           the residual only refers to these
+ */
+
+import (
+	"raygun/log"
+	"raygun/opa"
+	"strings"
+)
+
+func evaluateCompileExpectation(expectation_type string, target string, response string) (bool, error) {
+
+	residual, err := opa.ParseCompileResult(response)
+	if err != nil {
+		return false, err
+	}
+
+	switch expectation_type {
+	case "residual":
+		verdict := residual.Verdict()
+		log.Debug("Residual is %s, expecting %s", verdict, target)
+		return verdict == target, nil
+
+	default:
+		allowed := make([]string, 0)
+		for _, reference := range strings.Split(target, ",") {
+			if strings.TrimSpace(reference) != "" {
+				allowed = append(allowed, reference)
+			}
+		}
+
+		outside, err := residual.ReferencesOutside(allowed)
+		if err != nil {
+			return false, err
+		}
+
+		if len(outside) > 0 {
+			log.Debug("Residual references outside of %s: %s", target, strings.Join(outside, ", "))
+		}
+
+		return len(outside) == 0, nil
+	}
+}
+
+/*
+ *  The residual queries as Rego, which read a lot better than the AST OPA returns
+ */
+func compileDetails(response string) string {
+
+	residual, err := opa.ParseCompileResult(response)
+	if err != nil {
+		return ""
+	}
+
+	return "residual " + residual.Verdict() + ":\n          " + strings.ReplaceAll(residual.String(), "\n", "\n          ")
+}
@@ -0,0 +1,63 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  --coverage: every request a test sends to OPA (or to an in-process engine) is
+ *  replayed through a coverage engine, loaded from the suite's bundle. Whatever OPA
+ *  answered is what the test is judged by, the replay only traces the evaluation.
+ *
+ *  The replay sees the bundle alone, not the data fixtures the suite writes, and
+ *  partial evaluation isn't replayed, since its residuals don't evaluate the rules.
+ */
+
+import (
+	"raygun/config"
+	"raygun/log"
+	"raygun/opa"
+	"raygun/types"
+)
+
+/*
+ *  The suite's coverage engine, or nil when coverage is off or there's no bundle
+ *  of our own to replay against
+ */
+func coverageEngine(suite types.TestSuite) *opa.CoverageEngine {
+
+	if !config.Coverage {
+		return nil
+	}
+
+	if suite.Opa.BundlePath == "" {
+		log.Warning("Suite %s has no local bundle, so there's no coverage for it", suite.Name)
+		return nil
+	}
+
+	coverage, err := opa.NewCoverageEngine(suite.Opa.BundlePath, suite.Opa.Signing)
+	if err != nil {
+		log.Warning("Unable to collect coverage for suite %s: %s", suite.Name, err.Error())
+		return nil
+	}
+
+	return coverage
+}
+
+/*
+ *  Send the request through the coverage engine too. A problem here costs some
+ *  coverage, not the test
+ */
+func (tr TestRunner) replayCoverage(mode string, input string, query string) {
+
+	if tr.Coverage == nil || mode == REQUEST_COMPILE {
+		return
+	}
+
+	replay := tr
+	replay.Evaluator = tr.Coverage
+
+	_, err := replay.evaluate(mode, input, query)
+	if err != nil {
+		log.Warning("Unable to replay test %s for coverage: %s", tr.Source.Name, err.Error())
+	}
+}
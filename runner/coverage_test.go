@@ -0,0 +1,49 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"raygun/opa"
+	"raygun/types"
+	"testing"
+)
+
+func TestReplayCoverage(t *testing.T) {
+
+	directory := t.TempDir()
+
+	policy := "package app\n\nallow if input.user == \"ray\"\n\nadmin if input.user == \"root\"\n\nroles := [\"admin\", \"viewer\"]\n"
+
+	os.WriteFile(filepath.Join(directory, "app.rego"), []byte(policy), 0644)
+
+	coverage, err := opa.NewCoverageEngine(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []types.TestRecord{
+		{DecisionPath: "/v0/data/app/allow", Input: types.TestInput{InputType: "inline", Value: `{"user": "ray"}`}},
+		{DecisionPath: QUERY_PATH, Query: `data.app.roles[_] == "admin"`},
+	}
+
+	for _, test := range tests {
+		runner := NewTestRunner(test)
+		runner.Coverage = coverage
+
+		input, query, err := runner.expandedRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runner.replayCoverage(requestMode(test), input, query)
+	}
+
+	report := coverage.Report()
+
+	if len(report.Files) != 1 || opa.LineRanges(report.Files[0].Covered) != "3, 7" || opa.LineRanges(report.Files[0].NotCovered) != "5" {
+		t.Errorf("expected only the admin rule to be missed: %+v", report.Files)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  Expectations that look inside the decision, rather than at the raw text
+ *  of the response
+ */
+
+import (
+	"encoding/json"
+	"raygun/types"
+)
+
+/*
+ *  OPA wraps the decision in {"result": ...}. An undefined decision has no result
+ *  at all, which we return as nil
+ */
+func decisionResult(response string) (interface{}, error) {
+
+	var wrapper map[string]interface{}
+
+	err := json.Unmarshal([]byte(response), &wrapper)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapper["result"], nil
+}
+
+func evaluateDecisionExpectation(expected types.TestExpectation, result interface{}) (bool, error) {
+
+	switch expected.ExpectationType {
+	case "message", "patch":
+		return evaluateAdmissionExpectation(expected, result)
+	default:
+		return evaluateEnvoyExpectation(expected, result)
+	}
+}
+
+/*
+ *  Find the allow/deny verdict in a decision, wherever the policy put it:
+ *
+ *    - a bare boolean
+ *    - an object with allowed or allow (Envoy, generic policies)
+ *    - the response of an AdmissionReview
+ *    - a set of deny messages (bare, or under deny), where an empty set means allowed
+ */
+func decisionAllowed(result interface{}) (bool, bool) {
+
+	switch v := result.(type) {
+	case bool:
+		return v, true
+	case []interface{}:
+		return len(v) == 0, true
+	case map[string]interface{}:
+		for _, key := range []string{"allowed", "allow"} {
+			if allowed, ok := v[key].(bool); ok {
+				return allowed, true
+			}
+		}
+
+		if response, ok := v["response"].(map[string]interface{}); ok {
+			return decisionAllowed(response)
+		}
+
+		if deny, ok := v["deny"].([]interface{}); ok {
+			return len(deny) == 0, true
+		}
+	}
+
+	return false, false
+}
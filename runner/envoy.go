@@ -0,0 +1,261 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  Support for testing Envoy ext_authz policies.
+ *
+ *  Testers describe the HTTP request in a compact form, and we expand it into the
+ *  CheckRequest JSON that the OPA-Envoy plugin would hand to the policy, including
+ *  the parsed_path and parsed_query conveniences the plugin adds.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"raygun/config"
+	"raygun/types"
+	"raygun/util"
+	"strings"
+)
+
+/*
+ *  Build the CheckRequest input document for an http-request input
+ */
+func buildEnvoyInput(request types.HttpRequest) (string, error) {
+
+	method := strings.ToUpper(request.Method)
+	if method == "" {
+		method = "GET"
+	}
+
+	scheme := request.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	protocol := request.Protocol
+	if protocol == "" {
+		protocol = "HTTP/1.1"
+	}
+
+	raw_path := request.Path
+	if raw_path == "" {
+		raw_path = "/"
+	}
+
+	path_only, raw_query, _ := strings.Cut(raw_path, "?")
+
+	query, err := url.ParseQuery(raw_query)
+	if err != nil {
+		return "", fmt.Errorf("invalid query string in http-request path %s: %w", raw_path, err)
+	}
+
+	// the query: map is added to whatever was already in the path, which is
+	// kept exactly as written, since that's what Envoy would send
+	extra := url.Values{}
+	for _, k := range util.SortMapKeys(request.Query) {
+		switch v := request.Query[k].(type) {
+		case []interface{}:
+			for _, element := range v {
+				extra.Add(k, fmt.Sprintf("%v", element))
+			}
+		default:
+			extra.Add(k, fmt.Sprintf("%v", v))
+		}
+	}
+
+	full_path := raw_path
+	if len(extra) > 0 {
+		separator := "?"
+		if strings.Contains(raw_path, "?") {
+			separator = "&"
+		}
+		full_path = raw_path + separator + extra.Encode()
+	}
+
+	for k, values := range extra {
+		query[k] = append(query[k], values...)
+	}
+
+	headers := make(map[string]interface{})
+	for k, v := range request.Headers {
+		headers[strings.ToLower(k)] = v
+	}
+	headers[":method"] = method
+	headers[":path"] = full_path
+	if request.Host != "" {
+		headers[":authority"] = request.Host
+	}
+
+	http := map[string]interface{}{
+		"method":   method,
+		"path":     full_path,
+		"scheme":   scheme,
+		"protocol": protocol,
+		"headers":  headers,
+	}
+
+	if request.Host != "" {
+		http["host"] = request.Host
+	}
+
+	if request.Body != "" {
+		http["body"] = request.Body
+		http["size"] = len(request.Body)
+	}
+
+	source := make(map[string]interface{})
+	if request.SourceIp != "" {
+		source["address"] = map[string]interface{}{
+			"socketAddress": map[string]interface{}{"address": request.SourceIp, "portValue": 0}}
+	}
+	if request.Principal != "" {
+		source["principal"] = request.Principal
+	}
+
+	input := map[string]interface{}{
+		"attributes": map[string]interface{}{
+			"source":  source,
+			"request": map[string]interface{}{"http": http},
+		},
+		"parsed_path":    parsePath(path_only),
+		"parsed_query":   parseQuery(query),
+		"truncated_body": false,
+		"version":        map[string]interface{}{"encoding": "protojson", "ext_authz": "v3"},
+	}
+
+	// like the plugin, only JSON bodies are parsed
+	if request.Body != "" {
+		var parsed_body interface{}
+		if json.Unmarshal([]byte(request.Body), &parsed_body) == nil {
+			input["parsed_body"] = parsed_body
+		}
+	}
+
+	b, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+/*
+ *  /a/b/c becomes ["a","b","c"], the way the OPA-Envoy plugin does it
+ */
+func parsePath(path string) []string {
+
+	parsed := make([]string, 0)
+
+	for _, segment := range strings.Split(strings.TrimLeft(path, "/"), "/") {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		parsed = append(parsed, segment)
+	}
+
+	return parsed
+}
+
+func parseQuery(query url.Values) map[string][]string {
+
+	parsed := make(map[string][]string)
+
+	for k, v := range query {
+		parsed[k] = v
+	}
+
+	return parsed
+}
+
+/*
+ *  The query: map with its ${} properties expanded. The rest of the request is
+ *  expanded along with the input, but by then the query is already URL encoded
+ */
+func expandQuery(resolver *config.PropertyResolver, query map[string]interface{}) map[string]interface{} {
+
+	if len(query) == 0 {
+		return query
+	}
+
+	expanded := make(map[string]interface{}, len(query))
+
+	for k, v := range query {
+		switch v := v.(type) {
+		case []interface{}:
+			elements := make([]interface{}, 0, len(v))
+			for _, element := range v {
+				elements = append(elements, resolver.ExpandProperties(fmt.Sprintf("%v", element)))
+			}
+			expanded[k] = elements
+		default:
+			expanded[k] = resolver.ExpandProperties(fmt.Sprintf("%v", v))
+		}
+	}
+
+	return expanded
+}
+
+/*
+ *  The Envoy assertions all compare a field of the decision. The decision may be a
+ *  bare boolean (allow), or an object with allowed, http_status, headers, etc.
+ */
+func evaluateEnvoyExpectation(expected types.TestExpectation, result interface{}) (bool, error) {
+
+	switch expected.ExpectationType {
+	case "allowed":
+		var want bool
+		if err := json.Unmarshal([]byte(expected.Target), &want); err != nil {
+			return false, fmt.Errorf("allowed expectation must be true or false: %s", expected.Target)
+		}
+
+		allowed, found := decisionAllowed(result)
+
+		return found && allowed == want, nil
+
+	case "http_status":
+		var want float64
+		if err := json.Unmarshal([]byte(expected.Target), &want); err != nil {
+			return false, fmt.Errorf("http_status expectation must be a number: %s", expected.Target)
+		}
+
+		decision, ok := result.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+
+		status, ok := decision["http_status"].(float64)
+
+		return ok && status == want, nil
+
+	case "headers", "response_headers_to_add":
+		var want map[string]interface{}
+		if err := json.Unmarshal([]byte(expected.Target), &want); err != nil {
+			return false, fmt.Errorf("%s expectation must be a map: %s", expected.ExpectationType, expected.Target)
+		}
+
+		decision, ok := result.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+
+		actual, ok := decision[expected.ExpectationType].(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+
+		// every expected header must be present, but extra headers are fine
+		for k, v := range want {
+			if fmt.Sprintf("%v", actual[k]) != fmt.Sprintf("%v", v) {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}
+
+	return false, fmt.Errorf("unsupported envoy expectation: %s", expected.ExpectationType)
+}
@@ -0,0 +1,198 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"raygun/config"
+	"raygun/generator"
+	"raygun/parser"
+	"raygun/types"
+	"testing"
+)
+
+func TestEnvoyInput_ParsedPathAndQuery(t *testing.T) {
+
+	request := types.HttpRequest{
+		Method:   "get",
+		Path:     "/api/v1/users?limit=10",
+		Headers:  map[string]string{"X-Api-Key": "demo-key-123"},
+		Query:    map[string]interface{}{"tag": []interface{}{"a", "b"}},
+		SourceIp: "10.11.12.13",
+	}
+
+	input, err := buildEnvoyInput(request)
+	if err != nil {
+		t.Fatalf("unable to build envoy input: %v", err)
+	}
+
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(input), &doc)
+
+	http := doc["attributes"].(map[string]interface{})["request"].(map[string]interface{})["http"].(map[string]interface{})
+
+	if http["method"] != "GET" {
+		t.Errorf("expected method GET, got: %v", http["method"])
+	}
+
+	if http["path"] != "/api/v1/users?limit=10&tag=a&tag=b" {
+		t.Errorf("expected the query to be merged into the path, got: %v", http["path"])
+	}
+
+	if http["headers"].(map[string]interface{})["x-api-key"] != "demo-key-123" {
+		t.Errorf("expected lower case header names, got: %v", http["headers"])
+	}
+
+	parsed_path, _ := json.Marshal(doc["parsed_path"])
+	if string(parsed_path) != `["api","v1","users"]` {
+		t.Errorf("unexpected parsed_path: %s", parsed_path)
+	}
+
+	parsed_query, _ := json.Marshal(doc["parsed_query"])
+	if string(parsed_query) != `{"limit":["10"],"tag":["a","b"]}` {
+		t.Errorf("unexpected parsed_query: %s", parsed_query)
+	}
+}
+
+func TestEnvoyInput_RawPathKept(t *testing.T) {
+
+	request := types.HttpRequest{Path: "/search?b=1&a=2&q=hello%20world"}
+
+	input, err := buildEnvoyInput(request)
+	if err != nil {
+		t.Fatalf("unable to build envoy input: %v", err)
+	}
+
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(input), &doc)
+
+	http := doc["attributes"].(map[string]interface{})["request"].(map[string]interface{})["http"].(map[string]interface{})
+
+	if http["path"] != "/search?b=1&a=2&q=hello%20world" {
+		t.Errorf("expected the path exactly as written, got: %v", http["path"])
+	}
+
+	if http["headers"].(map[string]interface{})[":path"] != "/search?b=1&a=2&q=hello%20world" {
+		t.Errorf("expected :path exactly as written, got: %v", http["headers"])
+	}
+
+	parsed_query, _ := json.Marshal(doc["parsed_query"])
+	if string(parsed_query) != `{"a":["2"],"b":["1"],"q":["hello world"]}` {
+		t.Errorf("unexpected parsed_query: %s", parsed_query)
+	}
+}
+
+func TestEnvoyExpectation_Decision(t *testing.T) {
+
+	decision, _ := decisionResult(`{"result":{"allowed":false,"http_status":403,"headers":{"x-reason":"denied","x-other":"1"}}}`)
+
+	expectations := []types.TestExpectation{
+		{ExpectationType: "allowed", Target: "false"},
+		{ExpectationType: "http_status", Target: "403"},
+		{ExpectationType: "headers", Target: `{"x-reason":"denied"}`},
+	}
+
+	for _, expected := range expectations {
+		passed, err := evaluateEnvoyExpectation(expected, decision)
+		if err != nil || !passed {
+			t.Errorf("expected %v to pass, got: %v %v", expected, passed, err)
+		}
+	}
+
+	passed, _ := evaluateEnvoyExpectation(types.TestExpectation{ExpectationType: "allowed", Target: "true"}, decision)
+	if passed {
+		t.Errorf("expected allowed: true to fail against a denied decision")
+	}
+
+	bare, _ := decisionResult(`{"result":true}`)
+	passed, _ = evaluateEnvoyExpectation(types.TestExpectation{ExpectationType: "allowed", Target: "true"}, bare)
+	if !passed {
+		t.Errorf("expected allowed: true to pass against a bare boolean decision")
+	}
+}
+
+const apiKeySpec = `
+openapi: 3.0.0
+info:
+  title: Pets
+  version: "1.0"
+security:
+  - apiKey: []
+components:
+  securitySchemes:
+    apiKey:
+      type: apiKey
+      in: query
+      name: api_key
+paths:
+  /pets:
+    get: {}
+`
+
+/*
+ *  A generated suite puts an apiKey that goes in the query into query:, as a property.
+ *  The property has to be expanded before the query is URL encoded into the path
+ */
+func TestEnvoyInput_GeneratedQueryApiKey(t *testing.T) {
+
+	previous := config.Resolver
+	defer func() { config.Resolver = previous }()
+
+	config.Resolver = config.NewPropertyResolver()
+
+	directory := t.TempDir()
+	spec := filepath.Join(directory, "spec.yaml")
+	os.WriteFile(spec, []byte(apiKeySpec), 0644)
+
+	generated, err := generator.NewOpenApiGenerator("/v1/data/envoy/authz/allow").Generate(spec)
+	if err != nil {
+		t.Fatalf("unable to generate suite: %v", err)
+	}
+
+	suite_file := filepath.Join(directory, "pets.raygun")
+	os.WriteFile(suite_file, []byte(generated), 0644)
+
+	suites, err := parser.NewRaygunParser(false).Parse([]string{suite_file})
+	if err != nil {
+		t.Fatalf("unable to parse the generated suite: %v", err)
+	}
+
+	// an OPA that answers with the input it was sent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer server.Close()
+
+	suite := suites[0]
+	suite.Opa.EndpointUrl = server.URL
+
+	test := suite.Tests[0]
+	test.Suite = suite
+
+	// the key is only known once the test runs, like a value captured by a scenario
+	testRunner := NewTestRunner(test)
+	testRunner.Resolver = config.Resolver.NewScope()
+	testRunner.Resolver.AddProperty("API_KEY", "k&y 1")
+
+	response, err := testRunner.Post()
+	if err != nil {
+		t.Fatalf("unable to run %s: %v", test.Name, err)
+	}
+
+	var document struct {
+		Input struct {
+			Attributes struct{ Request struct{ Http struct{ Path string } } }
+		}
+	}
+	json.Unmarshal([]byte(response), &document)
+
+	if path := document.Input.Attributes.Request.Http.Path; path != "/pets?api_key=k%26y+1" {
+		t.Errorf("expected the api key in the path, got %s", path)
+	}
+}
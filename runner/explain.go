@@ -0,0 +1,129 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  --explain: when a test fails, send the same request again with ?explain=...&pretty=true
+ *  and keep OPA's trace with the result, so whoever gets the failure doesn't have
+ *  to reproduce it to see why the policy decided what it did.
+ *
+ *  The v0 and default decision APIs can't explain, so those are asked through
+ *  their /v1/data equivalents.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"raygun/config"
+	"raygun/log"
+	"raygun/opa"
+	"strings"
+)
+
+func (tr TestRunner) Explain(explain_mode string) (string, error) {
+
+	input, query, err := tr.expandedRequest()
+	if err != nil {
+		return "", err
+	}
+
+	mode := requestMode(tr.Source)
+
+	if bareResponse(mode) {
+		tr.Source.DecisionPath = v1DecisionPath(mode, tr.Source.DecisionPath)
+		mode = REQUEST_DATA
+	}
+
+	if tr.Evaluator != nil {
+
+		explainer, ok := tr.Evaluator.(opa.ExplainEvaluator)
+		if !ok {
+			return "", fmt.Errorf("the %s engine can't explain its decisions", tr.Source.Suite.Opa.Engine)
+		}
+
+		if mode == REQUEST_QUERY || mode == REQUEST_COMPILE {
+			return "", fmt.Errorf("the %s engine only explains decisions, not queries", tr.Source.Suite.Opa.Engine)
+		}
+
+		body := ""
+		if input != "" {
+			body = optionally_add_input_key(input)
+		}
+
+		return explainer.Explain(tr.Source.DecisionPath, body, explain_mode)
+	}
+
+	request, err := tr.httpRequest(mode, input, query)
+	if err != nil {
+		return "", err
+	}
+
+	separator := "?"
+	if strings.Contains(request.Url, "?") {
+		separator = "&"
+	}
+
+	request.Url += separator + "explain=" + explain_mode + "&pretty=true"
+
+	response, err := request.send()
+	if err != nil {
+		return "", err
+	}
+
+	return explanationText(response)
+}
+
+/*
+ *  A failed test is still a failed test without its explanation, so a problem
+ *  getting one goes into the report instead
+ */
+func explainFailure(testRunner TestRunner) string {
+
+	explanation, err := testRunner.Explain(config.Explain)
+	if err != nil {
+		log.Warning("Unable to explain test %s: %s", testRunner.Source.Name, err.Error())
+		return "unable to explain: " + err.Error()
+	}
+
+	if explanation == "" {
+		return fmt.Sprintf("(no trace from --explain %s)", config.Explain)
+	}
+
+	return explanation
+}
+
+/*
+ *  /v0/data/a/b -> /v1/data/a/b, and / -> the default decision
+ */
+func v1DecisionPath(mode string, decision_path string) string {
+
+	if mode == REQUEST_DEFAULT {
+		return DEFAULT_DECISION_PATH
+	}
+
+	return "/v1/data" + strings.TrimPrefix(decision_path, "/v0/data")
+}
+
+/*
+ *  With pretty=true, the explanation is the trace as a list of lines
+ */
+func explanationText(response string) (string, error) {
+
+	var document struct {
+		Explanation []string `json:"explanation"`
+		Code        string   `json:"code"`
+		Message     string   `json:"message"`
+	}
+
+	err := json.Unmarshal([]byte(response), &document)
+	if err != nil {
+		return "", fmt.Errorf("unexpected explanation response: %w", err)
+	}
+
+	if document.Code != "" {
+		return "", fmt.Errorf("%s: %s", document.Code, document.Message)
+	}
+
+	return strings.TrimRight(strings.Join(document.Explanation, "\n"), "\n"), nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"raygun/opa"
+	"raygun/types"
+	"strings"
+	"testing"
+)
+
+func TestExplain_Embedded(t *testing.T) {
+
+	directory := t.TempDir()
+
+	policy := "package app\n\nallow if {\n\ttrace(\"checking the user\")\n\tstartswith(input.user, \"r\")\n}\n"
+
+	os.WriteFile(filepath.Join(directory, "app.rego"), []byte(policy), 0644)
+
+	engine, err := opa.NewEmbeddedEngine(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := types.TestInput{InputType: "inline", Value: `{"user": "bob"}`}
+
+	for _, decision_path := range []string{"/v1/data/app/allow", "/v0/data/app/allow"} {
+
+		runner := NewTestRunner(types.TestRecord{DecisionPath: decision_path, Input: input})
+		runner.Evaluator = engine
+
+		full, err := runner.Explain(opa.EXPLAIN_FULL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(full, `Fail startswith(__local0__, "r")`) {
+			t.Errorf("%s: expected the failed comparison in the trace:\n%s", decision_path, full)
+		}
+
+		notes, err := runner.Explain(opa.EXPLAIN_NOTES)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(notes, "Note \"checking the user\"") || strings.Contains(notes, "Fail") {
+			t.Errorf("%s: expected only the notes:\n%s", decision_path, notes)
+		}
+	}
+}
+
+func TestExplanationText(t *testing.T) {
+
+	text, err := explanationText(`{"explanation": ["query:1 Enter data.app.allow = _", "query:1 | Fail data.app.allow = _"]}`)
+	if err != nil || text != "query:1 Enter data.app.allow = _\nquery:1 | Fail data.app.allow = _" {
+		t.Errorf("unexpected explanation %q %v", text, err)
+	}
+
+	if _, err := explanationText(`{"code": "invalid_parameter", "message": "bad"}`); err == nil {
+		t.Errorf("expected an OPA error to come back as an error")
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  Data fixtures: documents written into OPA with the Data API before a suite (or
+ *  a single test) runs, and removed afterwards, so the same policy can be tested
+ *  against different data without a bundle for each variation.
+ *
+ *  Before writing, we remember what was there: the old document, or the first part
+ *  of the path that didn't exist yet. Afterwards we put the old document back, or
+ *  delete what we created, so the next suite sees OPA as it was.
+ *
+ *  OPA won't write under a bundle's roots, and a bundle that doesn't declare any
+ *  owns all of data, so fixture paths need to be outside the roots.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"raygun/log"
+	"raygun/types"
+	"raygun/util"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const FIXTURE_METHOD_PUT = "put"
+const FIXTURE_METHOD_PATCH = "patch"
+
+/*
+ *  What we need to undo a fixture
+ */
+type appliedFixture struct {
+	path     string          // the fixture's path, e.g. envoy/network/subnets
+	created  string          // the first part of the path that didn't exist before, if any
+	previous json.RawMessage // the old document, when the whole path existed
+}
+
+/*
+ *  Write the fixtures in order. If one fails, the ones already written are
+ *  removed again before we return the error
+ */
+func applyFixtures(agent_url string, directory string, fixtures []types.DataFixture) ([]appliedFixture, error) {
+
+	applied := make([]appliedFixture, 0, len(fixtures))
+
+	for _, fixture := range fixtures {
+
+		a, err := applyFixture(agent_url, directory, fixture)
+		if err != nil {
+			restoreFixtures(agent_url, applied)
+			return nil, err
+		}
+
+		applied = append(applied, a)
+	}
+
+	return applied, nil
+}
+
+/*
+ *  Undo the fixtures, the last one first
+ */
+func restoreFixtures(agent_url string, applied []appliedFixture) error {
+
+	var first_err error
+
+	for i := len(applied) - 1; i >= 0; i-- {
+
+		a := applied[i]
+
+		var err error
+
+		if a.created != "" {
+			log.Debug("Removing data fixture: deleting %s", a.created)
+			err = dataRequest(http.MethodDelete, agent_url, a.created, "", nil)
+		} else {
+			log.Debug("Removing data fixture: restoring %s", a.path)
+			err = dataRequest(http.MethodPut, agent_url, a.path, "application/json", a.previous)
+		}
+
+		if err != nil {
+			log.Error("Unable to remove the data fixture at %s: %s", a.path, err.Error())
+			if first_err == nil {
+				first_err = err
+			}
+		}
+	}
+
+	return first_err
+}
+
+func applyFixture(agent_url string, directory string, fixture types.DataFixture) (appliedFixture, error) {
+
+	path := fixturePath(fixture.Path)
+
+	if path == "" {
+		return appliedFixture{}, fmt.Errorf("a data fixture needs a path below data")
+	}
+
+	value, err := fixtureValue(directory, fixture)
+	if err != nil {
+		return appliedFixture{}, fmt.Errorf("data fixture %s: %w", path, err)
+	}
+
+	applied := appliedFixture{path: path}
+
+	// find the first part of the path that doesn't exist yet, or the old document
+	segments := strings.Split(path, "/")
+
+	for i := range segments {
+
+		prefix := strings.Join(segments[:i+1], "/")
+
+		document, found, err := getData(agent_url, prefix)
+		if err != nil {
+			return applied, fmt.Errorf("data fixture %s: %w", path, err)
+		}
+
+		if !found {
+			applied.created = prefix
+			break
+		}
+
+		if i == len(segments)-1 {
+			applied.previous = document
+		}
+	}
+
+	method := strings.ToLower(fixture.Method)
+
+	switch method {
+	case "", FIXTURE_METHOD_PUT:
+		log.Debug("Writing data fixture %s", path)
+		err = dataRequest(http.MethodPut, agent_url, path, "application/json", value)
+	case FIXTURE_METHOD_PATCH:
+		log.Debug("Patching data fixture %s", path)
+		err = dataRequest(http.MethodPatch, agent_url, path, "application/json-patch+json", value)
+	default:
+		err = fmt.Errorf("unsupported method %s (expecting %s or %s)", fixture.Method, FIXTURE_METHOD_PUT, FIXTURE_METHOD_PATCH)
+	}
+
+	if err != nil {
+		return applied, fmt.Errorf("data fixture %s: %w", path, err)
+	}
+
+	return applied, nil
+}
+
+/*
+ *  envoy/network, /envoy/network/ and /v1/data/envoy/network are all the same path
+ */
+func fixturePath(path string) string {
+
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "/v1/data")
+
+	return strings.Trim(path, "/")
+}
+
+/*
+ *  The fixture's document, as JSON
+ */
+func fixtureValue(directory string, fixture types.DataFixture) ([]byte, error) {
+
+	if fixture.File != "" && fixture.Value != nil {
+		return nil, fmt.Errorf("set a value or a file, not both")
+	}
+
+	value := fixture.Value
+
+	if fixture.File != "" {
+
+		contents, err := util.ReadFile(directory, fixture.File)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.ToLower(filepath.Ext(fixture.File)) == ".json" {
+			err = json.Unmarshal([]byte(contents), &value)
+		} else {
+			err = yaml.Unmarshal([]byte(contents), &value)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", fixture.File, err)
+		}
+
+	} else if text, ok := value.(string); ok {
+
+		// a JSON object or array, in a string
+		trimmed := strings.TrimSpace(text)
+
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var parsed interface{}
+			if json.Unmarshal([]byte(trimmed), &parsed) == nil {
+				value = parsed
+			}
+		}
+	}
+
+	if value == nil {
+		return nil, fmt.Errorf("no value or file")
+	}
+
+	return json.Marshal(value)
+}
+
+/*
+ *  The document at a path, and whether there is one
+ */
+func getData(agent_url string, path string) (json.RawMessage, bool, error) {
+
+	response, err := httpClient.Get(agent_url + "/v1/data/" + path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("GET /v1/data/%s returned %d: %s", path, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var document struct {
+		Result json.RawMessage `json:"result"`
+	}
+
+	err = json.Unmarshal(body, &document)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return document.Result, document.Result != nil, nil
+}
+
+func dataRequest(method string, agent_url string, path string, content_type string, body []byte) error {
+
+	request, err := http.NewRequest(method, agent_url+"/v1/data/"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if content_type != "" {
+		request.Header.Set("Content-Type", content_type)
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNoContent || response.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	response_body, _ := io.ReadAll(response.Body)
+
+	message := strings.TrimSpace(string(response_body))
+
+	var opa_error struct {
+		Message string `json:"message"`
+	}
+
+	if json.Unmarshal(response_body, &opa_error) == nil && opa_error.Message != "" {
+		message = opa_error.Message
+	}
+
+	if strings.Contains(message, "owned by bundle") {
+		message += " (fixture paths need to be outside the bundle's roots, and a bundle without roots owns all of data)"
+	}
+
+	return fmt.Errorf("%s /v1/data/%s returned %d: %s", method, path, response.StatusCode, message)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"raygun/types"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+ *  Just enough of the OPA Data API to apply and restore fixtures: GET, PUT and
+ *  DELETE of documents, with missing parents created on PUT
+ */
+func newFakeDataApi(t *testing.T, data map[string]interface{}) *httptest.Server {
+
+	var lock sync.Mutex
+
+	lookup := func(segments []string, create bool) (map[string]interface{}, string) {
+		current := data
+		for _, segment := range segments[:len(segments)-1] {
+			next, ok := current[segment].(map[string]interface{})
+			if !ok {
+				if !create {
+					return nil, ""
+				}
+				next = make(map[string]interface{})
+				current[segment] = next
+			}
+			current = next
+		}
+		return current, segments[len(segments)-1]
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/data"), "/"), "/")
+
+		switch r.Method {
+		case http.MethodGet:
+			parent, key := lookup(segments, false)
+			if value, found := parent[key]; parent != nil && found {
+				json.NewEncoder(w).Encode(map[string]interface{}{"result": value})
+				return
+			}
+			w.Write([]byte("{}"))
+		case http.MethodPut:
+			var value interface{}
+			body, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &value); err != nil {
+				t.Errorf("PUT with invalid JSON: %s", string(body))
+			}
+			parent, key := lookup(segments, true)
+			parent[key] = value
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			parent, key := lookup(segments, false)
+			if parent != nil {
+				delete(parent, key)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func TestFixtures_ApplyAndRestore(t *testing.T) {
+
+	data := map[string]interface{}{"envoy": map[string]interface{}{"regulations": "original"}}
+
+	server := newFakeDataApi(t, data)
+	defer server.Close()
+
+	directory := t.TempDir()
+	os.WriteFile(filepath.Join(directory, "subnets.yaml"), []byte("vpn: [10.11.12.0/24]\n"), 0644)
+
+	fixtures := []types.DataFixture{
+		{Path: "/v1/data/envoy/regulations", Value: map[string]interface{}{"approved_locations": []interface{}{"texas"}}},
+		{Path: "envoy/network/subnets", File: "subnets.yaml"},
+		{Path: "/limits/", Value: `{"ray": 3}`},
+	}
+
+	applied, err := applyFixtures(server.URL, directory, fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	during, _ := json.Marshal(data)
+
+	expected := `{"envoy":{"network":{"subnets":{"vpn":["10.11.12.0/24"]}},"regulations":{"approved_locations":["texas"]}},"limits":{"ray":3}}`
+
+	if string(during) != expected {
+		t.Errorf("unexpected data with the fixtures applied: %s", string(during))
+	}
+
+	if applied[1].created != "envoy/network" || applied[2].created != "limits" || applied[0].created != "" {
+		t.Errorf("unexpected created paths: %+v", applied)
+	}
+
+	err = restoreFixtures(server.URL, applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, _ := json.Marshal(data)
+
+	if string(after) != `{"envoy":{"regulations":"original"}}` {
+		t.Errorf("the data wasn't restored: %s", string(after))
+	}
+}
+
+func TestFixtures_Invalid(t *testing.T) {
+
+	server := newFakeDataApi(t, map[string]interface{}{})
+	defer server.Close()
+
+	invalid := []types.DataFixture{
+		{Path: "/v1/data/", Value: "x"},
+		{Path: "a"},
+		{Path: "a", Value: "x", File: "a.json"},
+		{Path: "a", Value: "x", Method: "post"},
+	}
+
+	for _, fixture := range invalid {
+		if _, err := applyFixtures(server.URL, t.TempDir(), []types.DataFixture{fixture}); err == nil {
+			t.Errorf("expected an error for %+v", fixture)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  --opa-metrics: ask OPA for its own timers and counters with every request
+ *  (?metrics=true&instrument=true), so evaluation time can be told apart from the
+ *  HTTP round trip that --perf-metrics measures.
+ *
+ *  The metrics are taken out of the response before the expectations see it, so
+ *  a test behaves the same with or without them.
+ */
+
+import (
+	"encoding/json"
+	"raygun/log"
+	"strings"
+)
+
+const METRICS_PARAMETERS = "metrics=true&instrument=true"
+
+/*
+ *  The v0 and default decision APIs don't report metrics, everything else does
+ */
+func withMetrics(mode string, request opaRequest) opaRequest {
+
+	if bareResponse(mode) {
+		return request
+	}
+
+	separator := "?"
+	if strings.Contains(request.Url, "?") {
+		separator = "&"
+	}
+
+	request.Url += separator + METRICS_PARAMETERS
+
+	return request
+}
+
+/*
+ *  Split OPA's metrics out of a response. Only the timers and counters are kept,
+ *  the instrumentation's histograms summarize a single request, so they don't add up
+ */
+func extractMetrics(response string) (string, map[string]int64) {
+
+	raw, start, end, found := findMember(response, "metrics")
+	if !found {
+		return response, nil
+	}
+
+	var values map[string]interface{}
+
+	err := json.Unmarshal(raw, &values)
+	if err != nil {
+		log.Debug("Unable to parse OPA metrics %s: %s", string(raw), err.Error())
+		return response, nil
+	}
+
+	metrics := make(map[string]int64)
+
+	for name, value := range values {
+		if number, ok := value.(float64); ok {
+			metrics[name] = int64(number)
+		}
+	}
+
+	return response[:start] + response[end:], metrics
+}
+
+/*
+ *  Find a member of a JSON object, and the span of the response that cutting it out
+ *  removes (with its comma), so the rest of the response is left exactly as OPA sent it
+ */
+func findMember(response string, name string) (json.RawMessage, int, int, bool) {
+
+	decoder := json.NewDecoder(strings.NewReader(response))
+
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('{') {
+		return nil, 0, 0, false
+	}
+
+	for first := true; decoder.More(); first = false {
+
+		// the end of the previous member, so its comma goes too (or this member's key, if it's the first)
+		start := int(decoder.InputOffset())
+
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, 0, 0, false
+		}
+
+		var value json.RawMessage
+
+		err = decoder.Decode(&value)
+		if err != nil {
+			return nil, 0, 0, false
+		}
+
+		if key != name {
+			continue
+		}
+
+		end := int(decoder.InputOffset())
+
+		// the first member has no comma before it, so take the one after it, up to the next member
+		if first {
+			rest := strings.TrimLeft(response[end:], " \t\r\n")
+			if strings.HasPrefix(rest, ",") {
+				end = len(response) - len(strings.TrimLeft(rest[1:], " \t\r\n"))
+			}
+		}
+
+		return value, start, end, true
+	}
+
+	return nil, 0, 0, false
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"testing"
+)
+
+func TestWithMetrics(t *testing.T) {
+
+	request := withMetrics(REQUEST_GET, opaRequest{Url: "http://localhost:8181/v1/data/app/allow?input=%7B%7D"})
+	if request.Url != "http://localhost:8181/v1/data/app/allow?input=%7B%7D&"+METRICS_PARAMETERS {
+		t.Errorf("unexpected url %s", request.Url)
+	}
+
+	request = withMetrics(REQUEST_DATA, opaRequest{Url: "http://localhost:8181/v1/data/app/allow"})
+	if request.Url != "http://localhost:8181/v1/data/app/allow?"+METRICS_PARAMETERS {
+		t.Errorf("unexpected url %s", request.Url)
+	}
+
+	request = withMetrics(REQUEST_V0, opaRequest{Url: "http://localhost:8181/v0/data/app/allow"})
+	if request.Url != "http://localhost:8181/v0/data/app/allow" {
+		t.Errorf("v0 requests shouldn't ask for metrics: %s", request.Url)
+	}
+}
+
+func TestExtractMetrics(t *testing.T) {
+
+	response := `{"result": true, "metrics": {"timer_rego_query_eval_ns": 1200, "counter_server_query_cache_hit": 1, "histogram_eval_op_plug": {"count": 3}}}`
+
+	stripped, metrics := extractMetrics(response)
+
+	if stripped != `{"result": true}` {
+		t.Errorf("expected the metrics to be removed, got %s", stripped)
+	}
+
+	if len(metrics) != 2 || metrics["timer_rego_query_eval_ns"] != 1200 || metrics["counter_server_query_cache_hit"] != 1 {
+		t.Errorf("unexpected metrics %v", metrics)
+	}
+
+	stripped, metrics = extractMetrics(`{"result": false}`)
+	if stripped != `{"result": false}` || metrics != nil {
+		t.Errorf("a response without metrics should be left alone: %s %v", stripped, metrics)
+	}
+}
+
+func TestExtractMetrics_KeepsResponse(t *testing.T) {
+
+	// pretty printed, with the metrics first, and characters json.Marshal would escape
+	response := "{\n  \"metrics\": {\n    \"timer_rego_query_eval_ns\": 1200\n  },\n  \"result\": {\n    \"z\": \"a<b & c>d\",\n    \"a\": 1\n  }\n}\n"
+
+	stripped, metrics := extractMetrics(response)
+
+	expected := "{\n  \"result\": {\n    \"z\": \"a<b & c>d\",\n    \"a\": 1\n  }\n}\n"
+
+	if stripped != expected {
+		t.Errorf("expected the response to be left as it was, got %q", stripped)
+	}
+
+	if metrics["timer_rego_query_eval_ns"] != 1200 {
+		t.Errorf("unexpected metrics %v", metrics)
+	}
+
+	// the metrics last, and on their own
+	for response, expected := range map[string]string{
+		`{"decision_id": "1", "result": "<ok>",  "metrics": {"counter_x": 2}}`: `{"decision_id": "1", "result": "<ok>"}`,
+		`{"metrics": {"counter_x": 2}}`:                                        `{}`,
+		`{"result": {"metrics": {"counter_x": 2}}}`:                            `{"result": {"metrics": {"counter_x": 2}}}`,
+	} {
+		stripped, _ := extractMetrics(response)
+		if stripped != expected {
+			t.Errorf("%s: expected %s, got %s", response, expected, stripped)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  Running suites in parallel.
+ *
+ *  Suites that share an OPA configuration are grouped, and each group gets its own
+ *  OPA process on its own ephemeral port (and its own log file). Within a group,
+ *  suites still run one after another, so a group only pays for one OPA startup.
+ *  The results are merged back in the original suite order, so the report is the
+ *  same from run to run, no matter which group finished first. With StopOnFailure,
+ *  a failure stops every group from running the suites that come after it, just
+ *  like a sequential run would.
+ */
+
+import (
+	"fmt"
+	"path/filepath"
+	"raygun/config"
+	"raygun/log"
+	"raygun/opa"
+	"raygun/types"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type suiteGroup struct {
+	indexes []int // positions in the original suite list
+	suites  []types.TestSuite
+}
+
+func (suiteRunner *SuiteRunner) executeParallel(workers int) (types.CombinedResult, error) {
+
+	results := types.CombinedResult{}
+
+	groups := groupSuitesByOpaConfiguration(suiteRunner.SuiteList)
+
+	log.Debug("executeParallel: %d suites in %d OPA groups, %d workers", len(suiteRunner.SuiteList), len(groups), workers)
+
+	for _, group := range groups {
+		err := assignPort(group)
+		if err != nil {
+			return results, err
+		}
+	}
+
+	suite_results := make([]*types.TestSuiteResult, len(suiteRunner.SuiteList))
+	group_errors := make([]error, len(groups))
+
+	// the position of the first failed suite, once StopOnFailure has something to stop
+	var first_failure atomic.Int64
+	first_failure.Store(int64(len(suiteRunner.SuiteList)))
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for g := range work {
+				group_errors[g] = executeGroup(groups[g], suite_results, &first_failure)
+			}
+		}()
+	}
+
+	for g := range groups {
+		work <- g
+	}
+	close(work)
+
+	wg.Wait()
+
+	// report the first error in group order, so the error is deterministic too
+	for g, err := range group_errors {
+		if err != nil {
+			log.Error("Suite group %d failed: %s", g, err.Error())
+			return results, err
+		}
+	}
+
+	for _, result := range suite_results {
+
+		if result == nil {
+			// the group stopped early, because of StopOnFailure
+			continue
+		}
+
+		results.ResultList = append(results.ResultList, *result)
+
+		if len(result.Failed) > 0 && config.StopOnFailure {
+			log.Debug("Detected test failures, and StopOnFailure is true, so we're dropping the remaining results")
+			break
+		}
+	}
+
+	return results, nil
+}
+
+/*
+ *  Run one group's suites serially on its own OPA, until a suite at or before
+ *  first_failure (in any group) fails
+ */
+func executeGroup(group *suiteGroup, suite_results []*types.TestSuiteResult, first_failure *atomic.Int64) error {
+
+	runner := NewSuiteRunner(group.suites)
+
+	defer runner.StopOpa()
+
+	for i, suite := range group.suites {
+
+		if int64(group.indexes[i]) > first_failure.Load() {
+			// a sequential run would have stopped before this suite
+			break
+		}
+
+		result, err := runner.ExecuteSuite(suite)
+		if err != nil {
+			return err
+		}
+
+		suite_results[group.indexes[i]] = &result
+
+		runner.LastSuite = &runner.SuiteList[i]
+
+		if len(result.Failed) > 0 && config.StopOnFailure {
+			stopAt(first_failure, int64(group.indexes[i]))
+			break
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  Lower first_failure to index, unless an earlier suite has already failed
+ */
+func stopAt(first_failure *atomic.Int64, index int64) {
+
+	for {
+		current := first_failure.Load()
+		if index >= current || first_failure.CompareAndSwap(current, index) {
+			return
+		}
+	}
+}
+
+/*
+ *  Groups are ordered by the first suite that uses them
+ */
+func groupSuitesByOpaConfiguration(suite_list []types.TestSuite) []*suiteGroup {
+
+	groups := make([]*suiteGroup, 0)
+	by_key := make(map[string]*suiteGroup)
+
+	for i, suite := range suite_list {
+
+		key := opaConfigurationKey(suite.Opa)
+
+		if suite.Reload != nil {
+			// a reload leaves OPA with a different bundle, so nothing else can share it
+			key = fmt.Sprintf("%s|reload %d", key, i)
+		}
+
+		group, found := by_key[key]
+		if !found {
+			group = &suiteGroup{}
+			by_key[key] = group
+			groups = append(groups, group)
+		}
+
+		group.indexes = append(group.indexes, i)
+		group.suites = append(group.suites, suite)
+	}
+
+	return groups
+}
+
+/*
+ *  Everything that would make DifferentOpaConfigurationThanLast start a new OPA,
+ *  except the port and log file, which we assign ourselves
+ */
+func opaConfigurationKey(config opa.OpaConfig) string {
+	return strings.Join([]string{config.Engine, config.OpaPath, config.BundlePath, config.ConfigFile, config.BundleUrl, config.EndpointUrl,
+		fmt.Sprintf("%v/%v", config.BundleServer, config.PollingInterval), config.Signing.Identity()}, "|")
+}
+
+/*
+ *  Give the group a free port, and a log file named after it, so parallel OPAs
+ *  don't write over each other's logs
+ */
+func assignPort(group *suiteGroup) error {
+
+	if group.suites[0].Opa.EndpointUrl != "" || group.suites[0].Opa.InProcess() {
+		// an existing OPA, or no OPA at all, so there's nothing to start
+		return nil
+	}
+
+	port, err := opa.FreePort()
+	if err != nil {
+		return fmt.Errorf("unable to find a free port for OPA: %w", err)
+	}
+
+	for i := range group.suites {
+
+		log_path := group.suites[i].Opa.LogPath
+		extension := filepath.Ext(log_path)
+
+		group.suites[i].Opa.OpaPort = port
+		group.suites[i].Opa.LogPath = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(log_path, extension), port, extension)
+	}
+
+	return nil
+}
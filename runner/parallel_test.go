@@ -0,0 +1,65 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"raygun/config"
+	"raygun/opa"
+	"raygun/types"
+	"sync/atomic"
+	"testing"
+)
+
+/*
+ *  With StopOnFailure, a group runs its suites up to the first failure in any
+ *  group, and no further, like a sequential run
+ */
+func TestExecuteGroup_StopOnFailure(t *testing.T) {
+
+	previous := config.StopOnFailure
+	defer func() { config.StopOnFailure = previous }()
+
+	config.StopOnFailure = true
+
+	// an OPA that denies everything
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":false}`))
+	}))
+	defer server.Close()
+
+	suite := func(name string) types.TestSuite {
+		return types.TestSuite{Name: name, Opa: opa.OpaConfig{EndpointUrl: server.URL}, Tests: []types.TestRecord{{
+			Name:         "allowed",
+			DecisionPath: "/v1/data/app/allow",
+			ExpectData:   []types.TestExpectation{{ExpectationType: "substring", Target: `"result":true`}},
+			Input:        types.TestInput{InputType: "inline", Value: "{}"},
+		}}}
+	}
+
+	group := &suiteGroup{indexes: []int{1, 3}, suites: []types.TestSuite{suite("one"), suite("three")}}
+	suite_results := make([]*types.TestSuiteResult, 4)
+
+	// suite 2, in another group, has already failed
+	var first_failure atomic.Int64
+	first_failure.Store(2)
+
+	err := executeGroup(group, suite_results, &first_failure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if suite_results[1] == nil || len(suite_results[1].Failed) != 1 {
+		t.Errorf("expected suite one to run, and fail")
+	}
+
+	if suite_results[3] != nil {
+		t.Errorf("expected suite three not to run after suite two failed")
+	}
+
+	if first_failure.Load() != 1 {
+		t.Errorf("expected suite one to become the first failure, got %d", first_failure.Load())
+	}
+}
@@ -0,0 +1,437 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  Hot reload mode: the suite's tests are sent to OPA over and over, while the
+ *  bundle server swaps the suite's bundle for the reload bundle partway through.
+ *
+ *  Every request is recorded against the phase it was sent in:
+ *
+ *    before      - the original bundle is being served
+ *    transition  - the new bundle is being served, but OPA hasn't activated it yet
+ *    after       - OPA reports the new revision as active (via /v1/status)
+ *
+ *  A test's expects hold before the swap, and after it too unless the test has
+ *  expects-after, for the answers the new bundle should give. During the transition
+ *  either set will do. Each test passes if every request it sent met the expectations
+ *  of its phase without errors. One more result records whether (and how quickly)
+ *  the new revision was activated.
+ */
+
+import (
+	"fmt"
+	"raygun/config"
+	"raygun/log"
+	"raygun/opa"
+	"raygun/types"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DEFAULT_RELOAD_SWAP_AFTER = 1 * time.Second
+const DEFAULT_RELOAD_SETTLE = 1 * time.Second
+const DEFAULT_RELOAD_TIMEOUT = 30 * time.Second
+
+// how often we ask OPA which revision is active
+const RELOAD_STATUS_POLL_INTERVAL = 50 * time.Millisecond
+
+// how many distinct responses (and errors) we show per phase
+const RELOAD_REPORT_LIMIT = 5
+
+var RELOAD_PHASES = []string{"before", "transition", "after"}
+
+/*
+ *  Everything one test saw during the run
+ */
+type reloadRecord struct {
+	lock      sync.Mutex
+	phased    bool // the test has expects-after
+	passed    map[string]int
+	failed    map[string]int
+	errors    map[string]int
+	like      map[string]int            // transition requests that answered like before or after (or both)
+	responses map[string]map[string]int // phase -> response -> count
+}
+
+func newReloadRecord() *reloadRecord {
+
+	record := &reloadRecord{
+		passed:    make(map[string]int),
+		failed:    make(map[string]int),
+		errors:    make(map[string]int),
+		like:      make(map[string]int),
+		responses: make(map[string]map[string]int),
+	}
+
+	for _, phase := range RELOAD_PHASES {
+		record.responses[phase] = make(map[string]int)
+	}
+
+	return record
+}
+
+/*
+ *  Prepare a reload suite before anything starts: the swap needs our bundle server,
+ *  and the reload bundle may need building
+ */
+func prepareReloadSuite(suite *types.TestSuite) error {
+
+	reload := suite.Reload
+
+	if suite.Opa.InProcess() || suite.Opa.EndpointUrl != "" {
+		return fmt.Errorf("reload needs an OPA that raygun starts, not the %s engine or an endpoint-url", suite.Opa.Engine)
+	}
+
+	suite.Opa.BundleServer = true
+
+	if reload.SourceDir != "" {
+		reload.SourceDir = suiteRelative(*suite, reload.SourceDir)
+
+		bundle_path, err := opa.BuildBundle(reload.SourceDir, reload.Roots, reload.Revision)
+		if err != nil {
+			return err
+		}
+		reload.BundlePath = bundle_path
+	}
+
+	if reload.BundlePath == "" {
+		return fmt.Errorf("reload needs a bundle-path or source-dir to swap in")
+	}
+
+	reload.BundlePath = suiteRelative(*suite, reload.BundlePath)
+
+	if reload.Revision == "" {
+		revision, err := opa.BundleRevision(reload.BundlePath)
+		if err != nil {
+			return err
+		}
+		if revision == "" {
+			return fmt.Errorf("reload bundle %s has no revision, so there's no way to tell when it's active. Set reload.revision", reload.BundlePath)
+		}
+		reload.Revision = revision
+	}
+
+	return nil
+}
+
+/*
+ *  Run the suite's tests as traffic, swap the bundle, and wait for the new revision
+ */
+func (suiteRunner *SuiteRunner) executeReload(suite types.TestSuite) (types.TestSuiteResult, error) {
+
+	results := types.TestSuiteResult{Source: suite}
+
+	reload := suite.Reload
+	opa_runner := suiteRunner.OpaRunner
+
+	if opa_runner == nil || opa_runner.BundleServer == nil {
+		return results, fmt.Errorf("suite %s: reload needs the bundle server", suite.Name)
+	}
+
+	runnable := 0
+	for _, test := range suite.Tests {
+		if !test.Skip {
+			runnable++
+		}
+	}
+
+	if runnable == 0 {
+		return results, fmt.Errorf("suite %s: reload needs at least one test that isn't skipped", suite.Name)
+	}
+
+	swap_after := durationOrDefault(reload.SwapAfter, DEFAULT_RELOAD_SWAP_AFTER)
+	settle := durationOrDefault(reload.Settle, DEFAULT_RELOAD_SETTLE)
+	timeout := durationOrDefault(reload.Timeout, DEFAULT_RELOAD_TIMEOUT)
+
+	records := make([]*reloadRecord, len(suite.Tests))
+	for i := range records {
+		records[i] = newReloadRecord()
+		records[i].phased = len(suite.Tests[i].ExpectAfterData) > 0
+	}
+
+	// the phase is read by every request, and moved along by the timeline below
+	var phase atomic.Value
+	phase.Store("before")
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+
+	workers := config.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var next atomic.Int64
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				i := int(next.Add(1)-1) % len(suite.Tests)
+				if suite.Tests[i].Skip {
+					continue
+				}
+				sendReloadRequest(suite, suite.Tests[i], records[i], phase.Load().(string))
+			}
+		}()
+	}
+
+	start := time.Now()
+
+	time.Sleep(swap_after)
+
+	log.Verbose("Suite %s: swapping in bundle %s (revision %s)", suite.Name, reload.BundlePath, reload.Revision)
+
+	err := opa_runner.BundleServer.Replace(reload.BundlePath)
+	if err != nil {
+		stop.Store(true)
+		wg.Wait()
+		return results, err
+	}
+
+	swapped := time.Now()
+	phase.Store("transition")
+
+	activated, last_revision := waitForRevision(opa_runner, reload.Revision, timeout)
+	time_to_activation := time.Since(swapped)
+
+	if activated {
+		phase.Store("after")
+		time.Sleep(settle)
+	}
+
+	stop.Store(true)
+	wg.Wait()
+
+	end := time.Now()
+
+	activation := types.TestResult{
+		Source: types.TestRecord{Suite: suite, Name: fmt.Sprintf("reload: revision %s activated", reload.Revision),
+			Description: fmt.Sprintf("swap to %s after %v", reload.BundlePath, swap_after)},
+		Start: swapped,
+		End:   end,
+	}
+
+	if activated {
+		activation.Status = config.PASS
+		activation.Duration = time_to_activation
+		activation.Actual = fmt.Sprintf("activated %v after the swap (%d bundle downloads, %d bundle requests)",
+			time_to_activation.Round(time.Millisecond), opa_runner.BundleServer.Downloads.Load(), opa_runner.BundleServer.Requests.Load())
+	} else {
+		activation.Status = config.FAIL
+		activation.Duration = timeout
+		activation.Actual = fmt.Sprintf("not activated within %v, the active revision is still %q", timeout, last_revision)
+	}
+
+	activation.Details = activation.Actual
+
+	results.Passed, results.Failed = appendByStatus(results.Passed, results.Failed, activation)
+
+	for i, test := range suite.Tests {
+
+		test.Suite = suite
+
+		result := types.TestResult{Source: test, Start: start, End: end, Duration: end.Sub(start)}
+
+		if test.Skip {
+			result.Status = config.SKIP
+			results.Skipped = append(results.Skipped, result)
+			continue
+		}
+
+		result.Actual, result.Details, result.Status = records[i].summary()
+
+		results.Passed, results.Failed = appendByStatus(results.Passed, results.Failed, result)
+	}
+
+	return results, nil
+}
+
+func sendReloadRequest(suite types.TestSuite, test types.TestRecord, record *reloadRecord, phase string) {
+
+	test.Suite = suite
+
+	testRunner := NewTestRunner(test)
+
+	response, err := testRunner.Post()
+
+	record.lock.Lock()
+	defer record.lock.Unlock()
+
+	if err != nil {
+		record.errors[phase]++
+		record.responses[phase]["error: "+err.Error()]++
+		return
+	}
+
+	record.responses[phase][response]++
+
+	like_before, err := matchesExpectations(testRunner, test.ExpectData, response)
+
+	// without expects-after, the same answers are expected all the way through
+	like_after := like_before
+	if err == nil && record.phased && phase != "before" {
+		like_after, err = matchesExpectations(testRunner, test.ExpectAfterData, response)
+	}
+
+	switch {
+	case err != nil:
+		record.errors[phase]++
+	case reloadPassed(phase, like_before, like_after):
+		record.passed[phase]++
+		if phase == "transition" && like_before {
+			record.like["before"]++
+		}
+		if phase == "transition" && like_after {
+			record.like["after"]++
+		}
+	default:
+		record.failed[phase]++
+	}
+}
+
+func matchesExpectations(testRunner TestRunner, expectations []types.TestExpectation, response string) (bool, error) {
+
+	testRunner.Source.ExpectData = expectations
+
+	result, err := testRunner.Evaluate(response)
+	if err != nil {
+		return false, err
+	}
+
+	return result.Status == config.PASS, nil
+}
+
+/*
+ *  Whether a response met the expectations of the phase it was sent in: the old
+ *  answers before the swap, the new ones after, and either during the transition
+ */
+func reloadPassed(phase string, like_before bool, like_after bool) bool {
+
+	switch phase {
+	case "before":
+		return like_before
+	case "after":
+		return like_after
+	default:
+		return like_before || like_after
+	}
+}
+
+/*
+ *  Poll until OPA reports the revision as active, or we run out of time
+ */
+func waitForRevision(opa_runner *opa.OpaRunner, revision string, timeout time.Duration) (bool, string) {
+
+	deadline := time.Now().Add(timeout)
+	last_revision := ""
+
+	for time.Now().Before(deadline) {
+
+		active, err := opa_runner.ActiveRevision()
+
+		if err != nil {
+			log.Debug("waitForRevision: %s", err.Error())
+		} else {
+			last_revision = active
+			if active == revision {
+				return true, active
+			}
+		}
+
+		time.Sleep(RELOAD_STATUS_POLL_INTERVAL)
+	}
+
+	return false, last_revision
+}
+
+/*
+ *  The counts for each phase (before: 120 passed, 0 failed, 0 errors; ...), the
+ *  same again with the distinct responses seen in each phase, and the status
+ */
+func (record *reloadRecord) summary() (string, string, string) {
+
+	record.lock.Lock()
+	defer record.lock.Unlock()
+
+	status := config.PASS
+
+	counts := make([]string, 0, len(RELOAD_PHASES))
+
+	for _, phase := range RELOAD_PHASES {
+
+		if record.failed[phase] > 0 || record.errors[phase] > 0 {
+			status = config.FAIL
+		}
+
+		count := fmt.Sprintf("%s: %d passed, %d failed, %d errors", phase, record.passed[phase], record.failed[phase], record.errors[phase])
+
+		if record.phased && phase == "transition" && record.passed[phase] > 0 {
+			count += fmt.Sprintf(" (%d like before, %d like after)", record.like["before"], record.like["after"])
+		}
+
+		counts = append(counts, count)
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString(strings.Join(counts, "; "))
+
+	for _, phase := range RELOAD_PHASES {
+
+		responses := record.responses[phase]
+
+		if len(responses) == 0 {
+			continue
+		}
+
+		keys := make([]string, 0, len(responses))
+		for k := range responses {
+			keys = append(keys, k)
+		}
+
+		// the most common first
+		sort.Slice(keys, func(a, b int) bool {
+			if responses[keys[a]] != responses[keys[b]] {
+				return responses[keys[a]] > responses[keys[b]]
+			}
+			return keys[a] < keys[b]
+		})
+
+		sb.WriteString(fmt.Sprintf("\n          %s responses:", phase))
+
+		for n, k := range keys {
+			if n == RELOAD_REPORT_LIMIT {
+				sb.WriteString(fmt.Sprintf(" (and %d more)", len(keys)-n))
+				break
+			}
+			sb.WriteString(fmt.Sprintf(" %dx %s", responses[k], strings.TrimSpace(k)))
+		}
+	}
+
+	return strings.Join(counts, "; "), sb.String(), status
+}
+
+func appendByStatus(passed []types.TestResult, failed []types.TestResult, result types.TestResult) ([]types.TestResult, []types.TestResult) {
+
+	if result.Status == config.PASS {
+		return append(passed, result), failed
+	}
+
+	return passed, append(failed, result)
+}
+
+func durationOrDefault(d time.Duration, default_duration time.Duration) time.Duration {
+
+	if d <= 0 {
+		return default_duration
+	}
+
+	return d
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"path/filepath"
+	"raygun/config"
+	"raygun/opa"
+	"raygun/types"
+	"strings"
+	"testing"
+)
+
+func TestReloadRecord_Summary(t *testing.T) {
+
+	record := newReloadRecord()
+
+	record.passed["before"] = 10
+	record.responses["before"][`{"result":true}`] = 10
+	record.passed["transition"] = 2
+	record.responses["transition"][`{"result":true}`] = 2
+
+	counts, details, status := record.summary()
+
+	if status != config.PASS {
+		t.Errorf("expected a pass, got %s", status)
+	}
+
+	if !strings.HasPrefix(counts, "before: 10 passed, 0 failed, 0 errors; transition: 2 passed") {
+		t.Errorf("unexpected counts: %s", counts)
+	}
+
+	if !strings.Contains(details, `transition responses: 2x {"result":true}`) || strings.Contains(details, "after responses") {
+		t.Errorf("unexpected details: %s", details)
+	}
+
+	// a single error during the transition fails the test
+	record.errors["transition"] = 1
+
+	_, _, status = record.summary()
+
+	if status != config.FAIL {
+		t.Errorf("expected a failure, got %s", status)
+	}
+}
+
+func TestPrepareReloadSuite_Validation(t *testing.T) {
+
+	suite := types.TestSuite{Name: "embedded", Reload: &types.ReloadConfig{BundlePath: "b.tar.gz", Revision: "b"}}
+	suite.Opa.Engine = opa.ENGINE_EMBEDDED
+
+	if err := prepareReloadSuite(&suite); err == nil {
+		t.Errorf("expected an error for a reload with the embedded engine")
+	}
+
+	suite = types.TestSuite{Name: "no bundle", Reload: &types.ReloadConfig{Revision: "b"}}
+
+	if err := prepareReloadSuite(&suite); err == nil {
+		t.Errorf("expected an error for a reload without a bundle")
+	}
+
+	suite = types.TestSuite{Name: "ok", Reload: &types.ReloadConfig{BundlePath: "b.tar.gz", Revision: "b"}}
+
+	if err := prepareReloadSuite(&suite); err != nil || !suite.Opa.BundleServer {
+		t.Errorf("expected the bundle server to be switched on, got %v", err)
+	}
+
+	// like the suite's own bundle, the reload bundle is relative to the suite
+	suite = types.TestSuite{Name: "relative", Directory: "suites", Reload: &types.ReloadConfig{BundlePath: "b.tar.gz", Revision: "b"}}
+
+	if err := prepareReloadSuite(&suite); err != nil || suite.Reload.BundlePath != filepath.Join("suites", "b.tar.gz") {
+		t.Errorf("expected the reload bundle to be relative to the suite, got %s (%v)", suite.Reload.BundlePath, err)
+	}
+}
+
+func TestReloadPassed(t *testing.T) {
+
+	cases := []struct {
+		phase       string
+		like_before bool
+		like_after  bool
+		passed      bool
+	}{
+		{"before", true, false, true},
+		{"before", false, true, false},
+		{"transition", true, false, true},
+		{"transition", false, true, true},
+		{"transition", false, false, false},
+		{"after", true, false, false},
+		{"after", false, true, true},
+	}
+
+	for _, c := range cases {
+		if reloadPassed(c.phase, c.like_before, c.like_after) != c.passed {
+			t.Errorf("%s (like before: %v, like after: %v): expected passed to be %v", c.phase, c.like_before, c.like_after, c.passed)
+		}
+	}
+}
+
+func TestReloadRecord_SummaryPhased(t *testing.T) {
+
+	record := newReloadRecord()
+	record.phased = true
+
+	// the answer changes with the bundle, as the test expects
+	record.passed["before"] = 10
+	record.responses["before"][`{"result":true}`] = 10
+	record.passed["transition"] = 3
+	record.like["before"] = 1
+	record.like["after"] = 2
+	record.responses["transition"][`{"result":true}`] = 1
+	record.responses["transition"][`{"result":false}`] = 2
+	record.passed["after"] = 8
+	record.responses["after"][`{"result":false}`] = 8
+
+	counts, _, status := record.summary()
+
+	if status != config.PASS {
+		t.Errorf("expected a pass, got %s", status)
+	}
+
+	if !strings.Contains(counts, "transition: 3 passed, 0 failed, 0 errors (1 like before, 2 like after)") {
+		t.Errorf("unexpected counts: %s", counts)
+	}
+}
@@ -0,0 +1,343 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  How a test asks OPA for its answer. Most tests POST {"input": ...} to a /v1/data
+ *  decision, but a test can also:
+ *
+ *    - GET a data document, passing any input as the ?input= parameter (method: GET)
+ *    - run an ad-hoc Rego query through /v1/query (query: ...)
+ *    - partially evaluate a query through /v1/compile (query: and unknowns:)
+ *    - POST the bare input to the /v0/data webhook API, or to the default decision at /
+ *
+ *  The v0 and default decision APIs answer with the bare value instead of
+ *  {"result": ...}, and with a 404 undefined_document error when there's no value.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"raygun/config"
+	"raygun/log"
+	"raygun/opa"
+	"raygun/types"
+	"strings"
+)
+
+const REQUEST_DATA = "data"
+const REQUEST_GET = "get"
+const REQUEST_QUERY = "query"
+const REQUEST_COMPILE = "compile"
+const REQUEST_V0 = "v0"
+const REQUEST_DEFAULT = "default"
+
+const QUERY_PATH = "/v1/query"
+const COMPILE_PATH = "/v1/compile"
+
+// what OPA answers at / unless it's configured with a different default_decision
+const DEFAULT_DECISION_PATH = "/v1/data/system/main"
+
+func requestMode(test types.TestRecord) string {
+
+	path, _, _ := strings.Cut(strings.TrimSpace(test.DecisionPath), "?")
+
+	switch {
+	case test.Query != "" && (len(test.Unknowns) > 0 || path == COMPILE_PATH):
+		return REQUEST_COMPILE
+	case test.Query != "":
+		return REQUEST_QUERY
+	case strings.EqualFold(test.Method, http.MethodGet):
+		return REQUEST_GET
+	case path == "" || path == "/":
+		return REQUEST_DEFAULT
+	case path == "/v0/data" || strings.HasPrefix(path, "/v0/data/"):
+		return REQUEST_V0
+	}
+
+	return REQUEST_DATA
+}
+
+/*
+ *  true when OPA answers with the bare decision, not {"result": ...}
+ */
+func bareResponse(mode string) bool {
+	return mode == REQUEST_V0 || mode == REQUEST_DEFAULT
+}
+
+/*
+ *  One request to OPA's REST API
+ */
+type opaRequest struct {
+	Method string
+	Url    string
+	Body   string
+}
+
+/*
+ *  Send the (already expanded) input and query the way the test's mode needs them
+ */
+func (tr TestRunner) send(mode string, input string, query string) (string, error) {
+
+	if mode == REQUEST_DATA && input == "" {
+		return "", fmt.Errorf("unsupported input type: %s", tr.Source.Input.InputType)
+	}
+
+	if tr.Evaluator != nil {
+		return tr.evaluate(mode, input, query)
+	}
+
+	request, err := tr.httpRequest(mode, input, query)
+	if err != nil {
+		return "", err
+	}
+
+	if config.OpaMetrics {
+		request = withMetrics(mode, request)
+	}
+
+	return request.send()
+}
+
+func (tr TestRunner) httpRequest(mode string, input string, query string) (opaRequest, error) {
+
+	agent_url := tr.Source.Suite.Opa.GetAgentUrl()
+	decision_path := tr.Source.DecisionPath
+
+	switch mode {
+	case REQUEST_GET:
+		return opaRequest{Method: http.MethodGet, Url: agent_url + decision_path + inputParameter(decision_path, input)}, nil
+
+	case REQUEST_QUERY, REQUEST_COMPILE:
+		if decision_path == "" {
+			decision_path = QUERY_PATH
+			if mode == REQUEST_COMPILE {
+				decision_path = COMPILE_PATH
+			}
+		}
+
+		body, err := queryBody(query, input, tr.Source.Unknowns)
+		if err != nil {
+			return opaRequest{}, err
+		}
+
+		return opaRequest{Method: http.MethodPost, Url: agent_url + decision_path, Body: body}, nil
+
+	case REQUEST_V0, REQUEST_DEFAULT:
+		if decision_path == "" {
+			decision_path = "/"
+		}
+
+		// the whole body is the input, so it isn't wrapped
+		return opaRequest{Method: http.MethodPost, Url: agent_url + decision_path, Body: unwrapInput(input)}, nil
+	}
+
+	return opaRequest{Method: http.MethodPost, Url: agent_url + decision_path, Body: optionally_add_input_key(input)}, nil
+}
+
+func (request opaRequest) send() (string, error) {
+
+	if request.Method == http.MethodGet {
+		return _get(request.Url)
+	}
+
+	return _post(request.Url, request.Body)
+}
+
+/*
+ *  The in-process engines only know {"input": ...} and {"result": ...}, so the other
+ *  modes are translated on the way in and out
+ */
+func (tr TestRunner) evaluate(mode string, input string, query string) (string, error) {
+
+	body := ""
+	if input != "" {
+		body = optionally_add_input_key(input)
+	}
+
+	switch mode {
+	case REQUEST_QUERY:
+		querier, ok := tr.Evaluator.(opa.QueryEvaluator)
+		if !ok {
+			return "", fmt.Errorf("test %s: ad-hoc queries need OPA or the embedded engine, not the %s engine", tr.Source.Name, tr.Source.Suite.Opa.Engine)
+		}
+		return querier.Query(query, body)
+
+	case REQUEST_COMPILE:
+		compiler, ok := tr.Evaluator.(opa.CompileEvaluator)
+		if !ok {
+			return "", fmt.Errorf("test %s: partial evaluation needs OPA or the embedded engine, not the %s engine", tr.Source.Name, tr.Source.Suite.Opa.Engine)
+		}
+		return compiler.Compile(query, tr.Source.Unknowns, body)
+
+	case REQUEST_V0, REQUEST_DEFAULT:
+		decision_path := tr.Source.DecisionPath
+		if mode == REQUEST_DEFAULT {
+			decision_path = DEFAULT_DECISION_PATH
+		}
+
+		response, err := tr.Evaluator.Evaluate(decision_path, body)
+		if err != nil {
+			return "", err
+		}
+
+		return bareDecision(decision_path, response)
+	}
+
+	return tr.Evaluator.Evaluate(tr.Source.DecisionPath, body)
+}
+
+/*
+ *  {"result": x} -> x, or the error OPA's v0 API gives for an undefined decision
+ */
+func bareDecision(decision_path string, response string) (string, error) {
+
+	var wrapper map[string]json.RawMessage
+
+	err := json.Unmarshal([]byte(response), &wrapper)
+	if err != nil {
+		return "", err
+	}
+
+	if result, found := wrapper["result"]; found {
+		return string(result), nil
+	}
+
+	ref, err := opa.DecisionRef(decision_path)
+	if err != nil {
+		return "", err
+	}
+
+	undefined, err := json.Marshal(map[string]string{"code": "undefined_document", "message": "document undefined: " + ref.String()})
+	if err != nil {
+		return "", err
+	}
+
+	return string(undefined), nil
+}
+
+/*
+ *  The decision the expectations look at, for a response in the test's mode
+ */
+func (tr TestRunner) decision(response string) (interface{}, error) {
+
+	if !bareResponse(requestMode(tr.Source)) {
+		return decisionResult(response)
+	}
+
+	var decision interface{}
+
+	err := json.Unmarshal([]byte(response), &decision)
+	if err != nil {
+		return nil, err
+	}
+
+	return decision, nil
+}
+
+/*
+ *  {"input": x} -> x, for the APIs that take the input as the whole body. Anything
+ *  else is already bare
+ */
+func unwrapInput(input string) string {
+
+	var wrapper map[string]json.RawMessage
+
+	err := json.Unmarshal([]byte(input), &wrapper)
+	if err != nil || len(wrapper) != 1 {
+		return input
+	}
+
+	if bare, found := wrapper["input"]; found {
+		return string(bare)
+	}
+
+	return input
+}
+
+/*
+ *  A GET has no body, so the input goes in the URL, as compact JSON
+ */
+func inputParameter(decision_path string, input string) string {
+
+	if input == "" {
+		return ""
+	}
+
+	compact := unwrapInput(input)
+
+	var document interface{}
+	if json.Unmarshal([]byte(compact), &document) == nil {
+		if b, err := json.Marshal(document); err == nil {
+			compact = string(b)
+		}
+	}
+
+	separator := "?"
+	if strings.Contains(decision_path, "?") {
+		separator = "&"
+	}
+
+	return separator + "input=" + url.QueryEscape(compact)
+}
+
+/*
+ *  {"query": "...", "input": ...} for /v1/query, plus "unknowns" for /v1/compile
+ */
+func queryBody(query string, input string, unknowns []string) (string, error) {
+
+	body := map[string]interface{}{"query": query}
+
+	if len(unknowns) > 0 {
+		body["unknowns"] = unknowns
+	}
+
+	if input != "" {
+		bare := unwrapInput(input)
+		if !json.Valid([]byte(bare)) {
+			return "", fmt.Errorf("the input for query %s is not valid JSON", query)
+		}
+		body["input"] = json.RawMessage(bare)
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+/*
+ *  the GET counterpart of _post
+ */
+func _get(url string) (string, error) {
+
+	log.Debug("Request URL: %s", url)
+
+	response, err := httpClient.Get(url)
+
+	if err != nil {
+		log.Error("Attempted to complete GET of %s -> %s", url, err.Error())
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	builderBuffer := new(strings.Builder)
+
+	_, err = io.Copy(builderBuffer, response.Body)
+
+	if err != nil {
+		log.Error("Error reading body of response: %s", err.Error())
+		return "", err
+	}
+
+	log.Debug("Response Content: %s", builderBuffer.String())
+
+	return builderBuffer.String(), nil
+}
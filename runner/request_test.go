@@ -0,0 +1,115 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"raygun/config"
+	"raygun/opa"
+	"raygun/types"
+	"testing"
+)
+
+func TestRequestModes_Http(t *testing.T) {
+
+	var method, uri, body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		method, uri, body = r.Method, r.URL.RequestURI(), string(content)
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer server.Close()
+
+	suite := types.TestSuite{Opa: opa.OpaConfig{EndpointUrl: server.URL}}
+	input := types.TestInput{InputType: "inline", Value: `{"user": "ray"}`}
+
+	tests := []struct {
+		name   string
+		test   types.TestRecord
+		method string
+		uri    string
+		body   string
+	}{
+		{"data", types.TestRecord{DecisionPath: "/v1/data/app/allow", Input: input}, "POST", "/v1/data/app/allow", `{"input":{"user": "ray"}}`},
+		{"get", types.TestRecord{DecisionPath: "/v1/data/app/allow", Method: "GET", Input: input}, "GET", "/v1/data/app/allow?input=%7B%22user%22%3A%22ray%22%7D", ""},
+		{"get without input", types.TestRecord{DecisionPath: "/v1/data/app?pretty=true", Method: "GET"}, "GET", "/v1/data/app?pretty=true", ""},
+		{"query", types.TestRecord{Query: "x := data.app.allow", Input: input}, "POST", "/v1/query", `{"input":{"user":"ray"},"query":"x := data.app.allow"}`},
+		{"compile", types.TestRecord{Query: "data.app.allow == true", Unknowns: []string{"input.resource"}, Input: input}, "POST", "/v1/compile", `{"input":{"user":"ray"},"query":"data.app.allow == true","unknowns":["input.resource"]}`},
+		{"v0", types.TestRecord{DecisionPath: "/v0/data/app/allow", Input: types.TestInput{InputType: "inline", Value: `{"input": {"user": "ray"}}`}}, "POST", "/v0/data/app/allow", `{"user": "ray"}`},
+		{"default", types.TestRecord{DecisionPath: "/", Input: input}, "POST", "/", `{"user": "ray"}`},
+	}
+
+	for _, test := range tests {
+
+		test.test.Suite = suite
+
+		if _, err := NewTestRunner(test.test).Post(); err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+
+		if method != test.method || uri != test.uri || body != test.body {
+			t.Errorf("%s: got %s %s %s, want %s %s %s", test.name, method, uri, body, test.method, test.uri, test.body)
+		}
+	}
+}
+
+func TestRequestModes_Embedded(t *testing.T) {
+
+	directory := t.TempDir()
+
+	policy := "package app\n\nallow if input.user == \"ray\"\n\nroles := [\"admin\", \"viewer\"]\n"
+	main := "package system\n\nmain := {\"user\": input.user}\n"
+
+	os.WriteFile(filepath.Join(directory, "app.rego"), []byte(policy), 0644)
+	os.WriteFile(filepath.Join(directory, "main.rego"), []byte(main), 0644)
+
+	engine, err := opa.NewEmbeddedEngine(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := types.TestInput{InputType: "inline", Value: `{"user": "ray"}`}
+
+	tests := []struct {
+		name string
+		test types.TestRecord
+		want string
+	}{
+		{"get", types.TestRecord{DecisionPath: "/v1/data/app/roles", Method: "GET"}, `{"result":["admin","viewer"]}`},
+		{"query", types.TestRecord{Query: "data.app.roles[i] == \"viewer\"", Input: input}, `{"result":[{"i":1}]}`},
+		{"v0", types.TestRecord{DecisionPath: "/v0/data/app/allow", Input: input}, `true`},
+		{"v0 undefined", types.TestRecord{DecisionPath: "/v0/data/app/allow", Input: types.TestInput{InputType: "inline", Value: `{}`}}, `{"code":"undefined_document","message":"document undefined: data.app.allow"}`},
+		{"default", types.TestRecord{DecisionPath: "/", Input: input}, `{"user":"ray"}`},
+	}
+
+	for _, test := range tests {
+
+		runner := NewTestRunner(test.test)
+		runner.Evaluator = engine
+
+		got, err := runner.Post()
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	// the bare decision is what the expectations look at
+	runner := NewTestRunner(types.TestRecord{DecisionPath: "/v0/data/app/allow", ExpectData: []types.TestExpectation{{ExpectationType: "allowed", Target: "true"}}})
+
+	result, err := runner.Evaluate("true")
+	if err != nil || result.Status != config.PASS {
+		t.Errorf("expected the bare decision to be allowed: %v %v", result.Status, err)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  Scenario tests: ordered steps that share state. A step can write to the Data
+ *  API, query a policy (with its own expectations), and capture values from the
+ *  response for later steps to use as ${name}, in their paths, inputs, data and
+ *  expectations.
+ *
+ *  The scenario stops at the first step that fails. Everything the steps wrote is
+ *  removed when the scenario ends, the same way data fixtures are.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"raygun/config"
+	"raygun/log"
+	"raygun/opa"
+	"raygun/types"
+	"raygun/util"
+	"strings"
+	"time"
+)
+
+func runScenario(suite types.TestSuite, test types.TestRecord, evaluator opa.Evaluator, coverage *opa.CoverageEngine) (types.TestResult, error) {
+
+	result := types.TestResult{Source: test, Status: config.PASS, Start: time.Now()}
+
+	// captured values belong to this scenario alone
+	scope := config.Resolver.NewScope()
+
+	agent_url := suite.Opa.GetAgentUrl()
+
+	var applied []appliedFixture
+
+	defer func() {
+		restoreFixtures(agent_url, applied)
+	}()
+
+	details := make([]string, 0, len(test.Scenario))
+
+	for i, step := range test.Scenario {
+
+		label := fmt.Sprintf("%d. %s", i+1, step.Name)
+
+		if len(step.Data) > 0 {
+
+			if evaluator != nil {
+				return result, fmt.Errorf("test %s, %s: data writes need an OPA process, not the %s engine", test.Name, step.Name, suite.Opa.Engine)
+			}
+
+			fixtures := expandFixtures(scope, step.Data)
+
+			step_applied, err := applyFixtures(agent_url, suite.Directory, fixtures)
+			applied = append(applied, step_applied...)
+
+			if err != nil {
+				result.Status = config.FAIL
+				result.Actual = err.Error()
+				details = append(details, fmt.Sprintf("%s: FAILED writing data: %s", label, err.Error()))
+				break
+			}
+
+			paths := make([]string, len(fixtures))
+			for n, fixture := range fixtures {
+				paths[n] = fixturePath(fixture.Path)
+			}
+
+			details = append(details, fmt.Sprintf("%s: wrote %s", label, strings.Join(paths, ", ")))
+		}
+
+		if !scenarioQuery(step) {
+			continue
+		}
+
+		step_test := scenarioStepTest(suite, test, step, scope)
+
+		testRunner := NewTestRunner(step_test)
+		testRunner.Evaluator = evaluator
+		testRunner.Resolver = scope
+		testRunner.Coverage = coverage
+
+		response, err := testRunner.Post()
+		if err != nil {
+			return result, fmt.Errorf("test %s, %s: %w", test.Name, step.Name, err)
+		}
+
+		result.Actual = response
+
+		if len(step_test.ExpectData) > 0 {
+
+			step_result, err := testRunner.Evaluate(response)
+			if err != nil {
+				return result, fmt.Errorf("test %s, %s: %w", test.Name, step.Name, err)
+			}
+
+			if step_result.Status != config.PASS {
+				// so the report shows what this step expected
+				result.Source.ExpectData = step_test.ExpectData
+				result.Status = config.FAIL
+
+				if config.Explain != opa.EXPLAIN_OFF {
+					result.Explanation = explainFailure(testRunner)
+				}
+				details = append(details, fmt.Sprintf("%s: FAILED %s", label, step_test.DecisionPath))
+				break
+			}
+		}
+
+		captured, err := captureValues(response, step.Captures, scope)
+		if err != nil {
+			result.Status = config.FAIL
+			details = append(details, fmt.Sprintf("%s: FAILED %s", label, err.Error()))
+			break
+		}
+
+		details = append(details, strings.TrimSpace(fmt.Sprintf("%s: passed %s %s", label, step_test.DecisionPath, captured)))
+	}
+
+	result.End = time.Now()
+	result.Duration = result.End.Sub(result.Start)
+	result.Details = strings.Join(details, "\n          ")
+
+	log.Debug("Scenario %s: %s\n%s", test.Name, result.Status, result.Details)
+
+	return result, nil
+}
+
+/*
+ *  true if the step queries a policy, rather than just writing data
+ */
+func scenarioQuery(step types.ScenarioStep) bool {
+	return len(step.ExpectData) > 0 || len(step.Captures) > 0 || step.Input.InputType != "" || step.DecisionPath != ""
+}
+
+/*
+ *  true if any step writes to the Data API
+ */
+func scenarioWritesData(test types.TestRecord) bool {
+
+	for _, step := range test.Scenario {
+		if len(step.Data) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ *  The step as a test of its own, with the captured values expanded
+ */
+func scenarioStepTest(suite types.TestSuite, test types.TestRecord, step types.ScenarioStep, scope *config.PropertyResolver) types.TestRecord {
+
+	decision_path := step.DecisionPath
+	if decision_path == "" {
+		decision_path = test.DecisionPath
+	}
+
+	input := step.Input
+	if input.InputType == "" {
+		input = types.TestInput{InputType: "inline", Value: "{}"}
+	}
+
+	expectations := make([]types.TestExpectation, len(step.ExpectData))
+	for i, expectation := range step.ExpectData {
+		expectations[i] = types.TestExpectation{ExpectationType: expectation.ExpectationType, Target: scope.ExpandProperties(expectation.Target)}
+	}
+
+	return types.TestRecord{
+		Suite:        suite,
+		Name:         fmt.Sprintf("%s: %s", test.Name, step.Name),
+		Description:  test.Description,
+		Input:        input,
+		DecisionPath: scope.ExpandProperties(decision_path),
+		Jwt:          test.Jwt,
+		ExpectData:   expectations,
+	}
+}
+
+/*
+ *  Pull the captured values out of the response, and add them to the scope. Strings
+ *  are captured as they are, anything else as JSON
+ */
+func captureValues(response string, captures []types.Capture, scope *config.PropertyResolver) (string, error) {
+
+	if len(captures) == 0 {
+		return "", nil
+	}
+
+	var document interface{}
+
+	err := json.Unmarshal([]byte(response), &document)
+	if err != nil {
+		return "", fmt.Errorf("unable to capture from a response that isn't JSON: %w", err)
+	}
+
+	captured := make([]string, 0, len(captures))
+
+	for _, capture := range captures {
+
+		value, err := util.JsonPath(document, capture.Path)
+		if err != nil {
+			return "", fmt.Errorf("capture %s: %w", capture.Name, err)
+		}
+
+		text, ok := value.(string)
+		if !ok {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return "", fmt.Errorf("capture %s: %w", capture.Name, err)
+			}
+			text = string(encoded)
+		}
+
+		scope.AddProperty(capture.Name, text)
+
+		captured = append(captured, fmt.Sprintf("%s=%s", capture.Name, text))
+	}
+
+	return "(captured " + strings.Join(captured, ", ") + ")", nil
+}
+
+/*
+ *  The step's data, with the captured values expanded
+ */
+func expandFixtures(scope *config.PropertyResolver, fixtures []types.DataFixture) []types.DataFixture {
+
+	expanded := make([]types.DataFixture, len(fixtures))
+
+	for i, fixture := range fixtures {
+
+		fixture.Path = scope.ExpandProperties(fixture.Path)
+		fixture.File = scope.ExpandProperties(fixture.File)
+		fixture.Value = expandValue(scope, fixture.Value)
+
+		expanded[i] = fixture
+	}
+
+	return expanded
+}
+
+/*
+ *  Expand every string in a decoded YAML/JSON value, keys included. A captured
+ *  value is always a string, even when it holds JSON
+ */
+func expandValue(scope *config.PropertyResolver, value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case string:
+		return scope.ExpandProperties(v)
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			expanded[scope.ExpandProperties(key)] = expandValue(scope, item)
+		}
+		return expanded
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, item := range v {
+			expanded[i] = expandValue(scope, item)
+		}
+		return expanded
+	}
+
+	return value
+}
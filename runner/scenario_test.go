@@ -0,0 +1,46 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"encoding/json"
+	"raygun/config"
+	"raygun/types"
+	"testing"
+)
+
+func TestScenario_CaptureAndExpand(t *testing.T) {
+
+	scope := config.NewPropertyResolver()
+
+	captures := []types.Capture{{Name: "token", Path: "$.result.session_id"}, {Name: "roles", Path: "$.result.roles"}}
+
+	captured, err := captureValues(`{"result": {"session_id": "s-ray", "roles": ["admin"]}}`, captures, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if captured != `(captured token=s-ray, roles=["admin"])` {
+		t.Errorf("unexpected captures: %s", captured)
+	}
+
+	fixtures := expandFixtures(scope, []types.DataFixture{
+		{Path: "sessions/${token}", Value: map[string]interface{}{"id": "${token}", "roles": "${roles}"}},
+		{Path: "raw", Value: `{"id": "${token}"}`},
+	})
+
+	encoded, _ := json.Marshal(fixtures[0].Value)
+
+	if fixtures[0].Path != "sessions/s-ray" || string(encoded) != `{"id":"s-ray","roles":"[\"admin\"]"}` {
+		t.Errorf("unexpected fixture: %s %s", fixtures[0].Path, string(encoded))
+	}
+
+	if fixtures[1].Value != `{"id": "s-ray"}` {
+		t.Errorf("unexpected fixture value: %v", fixtures[1].Value)
+	}
+
+	if _, err := captureValues(`{"result": {}}`, captures, scope); err == nil {
+		t.Errorf("expected an error for a missing capture")
+	}
+}
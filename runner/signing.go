@@ -0,0 +1,117 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+/*
+ *  Rejection tests for signed bundles: we make a tampered copy of the suite's
+ *  bundle (a policy file changed, the signatures left alone) and an unsigned copy,
+ *  and check that each of them stops OPA (or the in-process engine) from loading.
+ *  A bundle that loads anyway means verification isn't doing its job.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"raygun/config"
+	"raygun/log"
+	"raygun/opa"
+	"raygun/types"
+	"strings"
+	"time"
+)
+
+type signatureRejection struct {
+	name        string
+	description string
+	build       func(string) (string, error)
+	reason      string // what OPA says when it rejects the bundle, as opposed to a port clash or similar
+}
+
+var SIGNATURE_REJECTIONS = []signatureRejection{
+	{"tampered", "a copy of the bundle with its policy and data files changed after signing", opa.TamperedBundle, "digest mismatch"},
+	{"unsigned", "a copy of the bundle without its .signatures.json", opa.UnsignedBundle, "bundle missing .signatures.json file"},
+}
+
+func checkSignatureRejections(suite types.TestSuite) []types.TestResult {
+
+	results := make([]types.TestResult, 0, len(SIGNATURE_REJECTIONS))
+
+	for _, rejection := range SIGNATURE_REJECTIONS {
+
+		start := time.Now()
+
+		result := types.TestResult{
+			Source: types.TestRecord{Suite: suite, Name: fmt.Sprintf("signing: %s bundle rejected", rejection.name),
+				Description: rejection.description},
+			Start: start,
+		}
+
+		result.Status, result.Actual, result.Details = checkSignatureRejection(suite, rejection)
+
+		result.End = time.Now()
+		result.Duration = result.End.Sub(start)
+
+		log.Verbose("Suite %s: %s bundle: %s", suite.Name, rejection.name, result.Actual)
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func checkSignatureRejection(suite types.TestSuite, rejection signatureRejection) (string, string, string) {
+
+	bundle_path, err := rejection.build(suite.Opa.BundlePath)
+	if err != nil {
+		return config.FAIL, fmt.Sprintf("unable to make a %s bundle: %s", rejection.name, err.Error()), ""
+	}
+	defer os.Remove(bundle_path)
+
+	opa_config := suite.Opa
+	opa_config.BundlePath = bundle_path
+
+	// the copy is loaded once, from the file. There's nothing to poll for
+	opa_config.BundleServer = false
+	opa_config.BundleUrl = ""
+
+	if !opa_config.InProcess() {
+
+		port, err := opa.FreePort()
+		if err != nil {
+			return config.FAIL, fmt.Sprintf("unable to find a free port for OPA: %s", err.Error()), ""
+		}
+
+		log_path := opa_config.LogPath
+		extension := filepath.Ext(log_path)
+
+		opa_config.OpaPort = port
+		opa_config.LogPath = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(log_path, extension), rejection.name, extension)
+	}
+
+	opa_runner := opa.NewOpaRunner(opa_config)
+
+	err = opa_runner.Start()
+
+	// OPA may still be running when Start gives up on it, and we're done with it either way
+	exited := opa_runner.Exited()
+	opa_runner.Stop()
+
+	if err == nil {
+		return config.FAIL, fmt.Sprintf("the %s bundle was accepted", rejection.name), ""
+	}
+
+	message := err.Error()
+
+	// a bundle that fails verification stops OPA, one that times out hasn't been judged yet
+	if !opa_config.InProcess() && !exited {
+		return config.FAIL, fmt.Sprintf("OPA didn't load the %s bundle, but didn't exit either", rejection.name), message
+	}
+
+	if !strings.Contains(message, rejection.reason) {
+		return config.FAIL, fmt.Sprintf("the %s bundle failed to load, but not because of its signature", rejection.name), message
+	}
+
+	return config.PASS, fmt.Sprintf("the %s bundle was rejected", rejection.name), message
+}
@@ -9,10 +9,14 @@ package runner
  */
 
 import (
+	"fmt"
+	"path/filepath"
 	"raygun/config"
 	"raygun/log"
 	"raygun/opa"
 	"raygun/types"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -34,6 +38,15 @@ func NewSuiteRunner(suite_list []types.TestSuite) SuiteRunner {
  */
 func (suiteRunner *SuiteRunner) Execute() (types.CombinedResult, error) {
 
+	err := suiteRunner.prepareSuites()
+	if err != nil {
+		return types.CombinedResult{}, err
+	}
+
+	if config.ParallelSuites > 1 {
+		return suiteRunner.executeParallel(config.ParallelSuites)
+	}
+
 	results := types.CombinedResult{}
 
 	for i, suite := range suiteRunner.SuiteList {
@@ -63,6 +76,56 @@ func (suiteRunner *SuiteRunner) Execute() (types.CombinedResult, error) {
 	return results, nil
 }
 
+/*
+ *  Everything that has to happen before any OPA starts. Suites with an opa source-dir
+ *  get a bundle built from it (suites sharing a source directory share the bundle),
+ *  and reload suites get their second bundle ready
+ */
+func (suiteRunner *SuiteRunner) prepareSuites() error {
+
+	for i := range suiteRunner.SuiteList {
+
+		suite := &suiteRunner.SuiteList[i]
+
+		if suite.Opa.SourceDir != "" {
+
+			// like inputs, the source directory is relative to the .raygun file
+			suite.Opa.SourceDir = suiteRelative(*suite, suite.Opa.SourceDir)
+
+			bundle_path, err := opa.BuildBundle(suite.Opa.SourceDir, suite.Opa.Roots, suite.Opa.Revision)
+			if err != nil {
+				return fmt.Errorf("suite %s: %w", suite.Name, err)
+			}
+
+			log.Verbose("Suite %s is using bundle %s, built from %s", suite.Name, bundle_path, suite.Opa.SourceDir)
+
+			suite.Opa.BundlePath = bundle_path
+			suite.Opa.DefaultBundle = false
+		}
+
+		if suite.Reload != nil {
+			err := prepareReloadSuite(suite)
+			if err != nil {
+				return fmt.Errorf("suite %s: %w", suite.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+ *  A path from the suite file, relative to the suite's directory unless it's absolute
+ */
+func suiteRelative(suite types.TestSuite, path string) string {
+
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(suite.Directory, path)
+}
+
 /*
  *  Execute a single suite of tests. If the OPA configuration is different than the last
  *  OPA configuration (different executable, different bundle, different log file), then this
@@ -85,70 +148,63 @@ func (suiteRunner *SuiteRunner) ExecuteSuite(suite types.TestSuite) (types.TestS
 		}
 	}
 
-	/*
-	 *   for each test, we POST data to OPA at the test-specified location, and
-	 *   compare the results to our expected results
-	 *
-	 *
-	 */
-	for _, test := range suite.Tests {
+	if config.OpaMetrics && suite.Opa.InProcess() {
+		log.Warning("Suite %s uses the %s engine, so there are no OPA metrics to collect", suite.Name, suite.Opa.Engine)
+	}
 
-		// this allows the test to refer to jwt config from the suite
-		// which will make test maintenance a little easier
-		test.Suite = suite
+	if len(suite.Data) > 0 {
 
-		testRunner := NewTestRunner(test)
+		if suite.Opa.InProcess() {
+			return results, fmt.Errorf("suite %s: data fixtures need an OPA process, not the %s engine", suite.Name, suite.Opa.Engine)
+		}
 
-		testResult := types.TestResult{Source: test}
+		applied, err := applyFixtures(suite.Opa.GetAgentUrl(), suite.Directory, suite.Data)
+		if err != nil {
+			return results, fmt.Errorf("suite %s: %w", suite.Name, err)
+		}
 
-		testStartTime := time.Now()
-		response, network_err := testRunner.Post()
+		defer restoreFixtures(suite.Opa.GetAgentUrl(), applied)
+	}
 
-		var eval_err error = nil
+	if suite.Reload != nil {
+		return suiteRunner.executeReload(suite)
+	}
 
-		if test.Skip {
-			testResult.Status = config.SKIP
-		} else if network_err != nil {
-			if config.SkipOnNetworkError {
-				testResult.Status = config.SKIP
-			} else {
-				log.Error("Failed to POST data to OPA: %s", network_err.Error())
-				return results, network_err
-			}
+	/*
+	 *   for each test, we POST data to OPA at the test-specified location, and
+	 *   compare the results to our expected results
+	 *
+	 *   with --concurrency, several tests are in flight at once, but we always
+	 *   process the outcomes in suite order, so the report doesn't change
+	 */
+	coverage := coverageEngine(suite)
 
-		} else {
-			testEndTime := time.Now()
+	test_results, test_errors := runTests(suite, config.Concurrency, suiteRunner.evaluator(), coverage)
 
-			testResult, eval_err = testRunner.Evaluate(response)
+	for i := range test_results {
 
-			log.Debug("test: %s result: %v", test, testResult)
+		if test_errors[i] != nil {
+			return results, test_errors[i]
+		}
 
-			/*
-			 *  This shouldn't happen, so its a fairly serious problem
-			 */
-			if eval_err != nil {
-				log.Error("Failed to evaluate response from OPA: %s", eval_err.Error())
-				return results, eval_err
-			}
+		testResult := test_results[i]
 
-			testResult.Start = testStartTime
-			testResult.End = testEndTime
-			testResult.Duration = testEndTime.Sub(testStartTime)
+		if testResult == nil {
+			// never dispatched, because an earlier test failed and StopOnFailure is set
+			break
 		}
 
 		switch testResult.Status {
 		case config.PASS:
-			results.Passed = append(results.Passed, testResult)
+			results.Passed = append(results.Passed, *testResult)
 		case config.FAIL:
-			results.Failed = append(results.Failed, testResult)
+			results.Failed = append(results.Failed, *testResult)
 		case config.SKIP:
-			results.Skipped = append(results.Skipped, testResult)
+			results.Skipped = append(results.Skipped, *testResult)
 		default:
 			log.Fatal("Unknown testResult Status for test %s : %s", testResult.Source, testResult.Status)
 		}
 
-		log.Debug("Expectations: type: %s, value: %s", test.ExpectData[0].ExpectationType, test.ExpectData[0].Target)
-
 		if len(results.Failed) > 0 && config.StopOnFailure {
 			log.Debug("Test failure detected and StopOnFailure is true, aborting...")
 			break
@@ -156,10 +212,184 @@ func (suiteRunner *SuiteRunner) ExecuteSuite(suite types.TestSuite) (types.TestS
 
 	}
 
+	if suite.BundleExpects != nil {
+		for _, result := range suiteRunner.checkBundleExpectations(suite) {
+			results.Passed, results.Failed = appendByStatus(results.Passed, results.Failed, result)
+		}
+	}
+
+	if suite.Opa.Signing != nil && suite.Opa.Signing.RejectionTests {
+		for _, result := range checkSignatureRejections(suite) {
+			results.Passed, results.Failed = appendByStatus(results.Passed, results.Failed, result)
+		}
+	}
+
+	if coverage != nil {
+		results.Coverage = coverage.Report()
+	}
+
 	return results, nil
 
 }
 
+/*
+ *  Run the suite's tests with a pool of workers. The results and errors are indexed
+ *  like suite.Tests. Once a test fails (and StopOnFailure is set) or errors, no new
+ *  tests are started, which leaves nil results behind
+ */
+func runTests(suite types.TestSuite, workers int, evaluator opa.Evaluator, coverage *opa.CoverageEngine) ([]*types.TestResult, []error) {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	// a test's data fixtures would be seen by every other test in flight
+	for _, test := range suite.Tests {
+		if (len(test.Data) > 0 || scenarioWritesData(test)) && workers > 1 {
+			log.Debug("Suite %s has tests with data fixtures, so its tests run one at a time", suite.Name)
+			workers = 1
+		}
+	}
+
+	test_results := make([]*types.TestResult, len(suite.Tests))
+	test_errors := make([]error, len(suite.Tests))
+	shared := sharedRequests(suite.Tests)
+
+	var stop atomic.Bool
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				result, err := runTest(suite, suite.Tests[i], shared[i], evaluator, coverage)
+
+				test_results[i] = &result
+				test_errors[i] = err
+
+				if err != nil || (result.Status == config.FAIL && config.StopOnFailure) {
+					stop.Store(true)
+				}
+			}
+		}()
+	}
+
+	for i := range suite.Tests {
+		if stop.Load() {
+			break
+		}
+		work <- i
+	}
+	close(work)
+
+	wg.Wait()
+
+	return test_results, test_errors
+}
+
+/*
+ *  Run a single test: send it to OPA and evaluate the response
+ */
+func runTest(suite types.TestSuite, test types.TestRecord, shared *sharedRequest, evaluator opa.Evaluator, coverage *opa.CoverageEngine) (types.TestResult, error) {
+
+	// this allows the test to refer to jwt config from the suite
+	// which will make test maintenance a little easier
+	test.Suite = suite
+
+	testRunner := NewTestRunner(test)
+	testRunner.Evaluator = evaluator
+	testRunner.Coverage = coverage
+	testRunner.Shared = shared
+
+	testResult := types.TestResult{Source: test}
+
+	if len(test.Data) > 0 && !test.Skip {
+
+		if evaluator != nil {
+			return testResult, fmt.Errorf("test %s: data fixtures need an OPA process, not the %s engine", test.Name, suite.Opa.Engine)
+		}
+
+		applied, err := applyFixtures(suite.Opa.GetAgentUrl(), suite.Directory, test.Data)
+		if err != nil {
+			return testResult, fmt.Errorf("test %s: %w", test.Name, err)
+		}
+
+		defer restoreFixtures(suite.Opa.GetAgentUrl(), applied)
+	}
+
+	if len(test.Scenario) > 0 {
+
+		if test.Skip {
+			testResult.Status = config.SKIP
+			return testResult, nil
+		}
+
+		return runScenario(suite, test, evaluator, coverage)
+	}
+
+	testStartTime := time.Now()
+	response, metrics, network_err := testRunner.PostWithMetrics()
+
+	var eval_err error = nil
+
+	if test.Skip {
+		testResult.Status = config.SKIP
+	} else if network_err != nil {
+		if config.SkipOnNetworkError {
+			testResult.Status = config.SKIP
+		} else {
+			log.Error("Failed to POST data to OPA: %s", network_err.Error())
+			return testResult, network_err
+		}
+
+	} else {
+		testEndTime := time.Now()
+
+		testResult, eval_err = testRunner.Evaluate(response)
+
+		log.Debug("test: %s result: %v", test, testResult)
+
+		/*
+		 *  This shouldn't happen, so its a fairly serious problem
+		 */
+		if eval_err != nil {
+			log.Error("Failed to evaluate response from OPA: %s", eval_err.Error())
+			return testResult, eval_err
+		}
+
+		testResult.Start = testStartTime
+		testResult.End = testEndTime
+		testResult.Duration = testEndTime.Sub(testStartTime)
+		testResult.Metrics = metrics
+
+		if testResult.Status == config.FAIL && config.Explain != opa.EXPLAIN_OFF {
+			testResult.Explanation = explainFailure(testRunner)
+		}
+	}
+
+	if len(test.ExpectData) > 0 {
+		log.Debug("Expectations: type: %s, value: %s", test.ExpectData[0].ExpectationType, test.ExpectData[0].Target)
+	}
+
+	return testResult, nil
+}
+
+/*
+ *  The in-process evaluator for the current OPA configuration, or nil when
+ *  tests go to an OPA server
+ */
+func (suiteRunner *SuiteRunner) evaluator() opa.Evaluator {
+
+	if suiteRunner.OpaRunner == nil {
+		return nil
+	}
+
+	return suiteRunner.OpaRunner.Evaluator
+}
+
 /*
  *  If there's an OPA process ID, this will stop it.  If not, it safely does nothing
  */
@@ -215,6 +445,11 @@ func (suiteRunner SuiteRunner) DifferentOpaConfigurationThanLast(suite types.Tes
 		return true
 	}
 
+	if suiteRunner.LastSuite.Reload != nil || suite.Reload != nil {
+		log.Debug("DifferentOpaConfigurationThanLast: a reload suite always gets an OPA of its own, starting from its own bundle")
+		return true
+	}
+
 	if suiteRunner.LastSuite.Opa.BundlePath != suite.Opa.BundlePath {
 		log.Debug("DifferentOpaConfigurationThanLast: Last suite bundlePath: %s is not the same as the new one %s", suiteRunner.LastSuite.Opa.BundlePath, suite.Opa.BundlePath)
 		return true
@@ -245,6 +480,13 @@ func (suiteRunner SuiteRunner) DifferentOpaConfigurationThanLast(suite types.Tes
 		return true
 	}
 
+	// everything else (the engine, config file, bundle server and signing) is
+	// compared the same way parallel runs group their suites
+	if opaConfigurationKey(suiteRunner.LastSuite.Opa) != opaConfigurationKey(suite.Opa) {
+		log.Debug("DifferentOpaConfigurationThanLast: Last Suite opa configuration: %s is different from the new configuration: %s", opaConfigurationKey(suiteRunner.LastSuite.Opa), opaConfigurationKey(suite.Opa))
+		return true
+	}
+
 	log.Debug("DifferentOpaConfigurationThanLast: No differences in Opa Config detected: %v vs %v", suiteRunner.LastSuite.Opa, suite.Opa)
 	return false
 }
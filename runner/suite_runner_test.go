@@ -0,0 +1,40 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"raygun/opa"
+	"raygun/types"
+	"testing"
+)
+
+func TestPrepareSuites_SourceDirRelativeToSuite(t *testing.T) {
+
+	directory := t.TempDir()
+
+	os.MkdirAll(filepath.Join(directory, "src"), 0755)
+	os.WriteFile(filepath.Join(directory, "src", "app.rego"), []byte("package app\n\nallow := true\n"), 0644)
+
+	// the tests run from runner/, so src/ only resolves against the suite's directory
+	suite := types.TestSuite{Name: "relative", Directory: directory, Opa: opa.OpaConfig{SourceDir: "src"}}
+
+	suiteRunner := NewSuiteRunner([]types.TestSuite{suite})
+
+	err := suiteRunner.prepareSuites()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prepared := suiteRunner.SuiteList[0]
+
+	if prepared.Opa.SourceDir != filepath.Join(directory, "src") {
+		t.Errorf("expected the source directory to be relative to the suite, got %s", prepared.Opa.SourceDir)
+	}
+
+	if _, err := os.Stat(prepared.Opa.BundlePath); err != nil {
+		t.Errorf("expected a bundle to be built: %v", err)
+	}
+}
@@ -11,13 +11,55 @@ import (
 	"raygun/config"
 	"raygun/jwt"
 	"raygun/log"
+	"raygun/opa"
 	"raygun/types"
 	"raygun/util"
 	"strings"
+	"sync"
 )
 
 type TestRunner struct {
-	Source types.TestRecord
+	Source    types.TestRecord
+	Evaluator opa.Evaluator            // when set, decisions are evaluated in-process instead of over HTTP
+	Resolver  *config.PropertyResolver // a scenario's scope, with its captured values. Defaults to config.Resolver
+	Coverage  *opa.CoverageEngine      // with --coverage, every request is replayed through it
+	Shared    *sharedRequest           // the decisions of one test send the same input (and JWT)
+}
+
+/*
+ *  The expanded input and query of a test with decisions, made once by whichever of
+ *  its decisions gets there first
+ */
+type sharedRequest struct {
+	once  sync.Once
+	input string
+	query string
+	err   error
+}
+
+/*
+ *  One sharedRequest per test with decisions, indexed like tests. Everything else
+ *  gets nil, and expands its own request
+ */
+func sharedRequests(tests []types.TestRecord) []*sharedRequest {
+
+	shared := make([]*sharedRequest, len(tests))
+	by_group := make(map[int]*sharedRequest)
+
+	for i, test := range tests {
+
+		if test.GroupId == 0 {
+			continue
+		}
+
+		if _, found := by_group[test.GroupId]; !found {
+			by_group[test.GroupId] = &sharedRequest{}
+		}
+
+		shared[i] = by_group[test.GroupId]
+	}
+
+	return shared
 }
 
 func NewTestRunner(test types.TestRecord) TestRunner {
@@ -28,35 +70,73 @@ func NewTestRunner(test types.TestRecord) TestRunner {
 
 var jwtBuilder jwt.JWTBuilder = jwt.NewJWTBuilder()
 
+// with --concurrency, the default of 2 idle connections per host means most
+// requests would open a new connection, so we keep more of them around
+var httpClient = &http.Client{Transport: &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	MaxIdleConns:        256,
+	MaxIdleConnsPerHost: 256,
+}}
+
 func (tr TestRunner) Post() (string, error) {
 
-	//	postUrl := fmt.Sprintf("http://localhost:%d%s", config.OpaPort, tr.Source.DecisionPath)
-	postUrl := fmt.Sprintf("%s%s", tr.Source.Suite.Opa.GetAgentUrl(), tr.Source.DecisionPath)
+	response, _, err := tr.PostWithMetrics()
 
-	log.Debug("postUrl: %s", postUrl)
+	return response, err
+}
 
-	preExpansionInput := ""
+/*
+ *  Post, and return OPA's metrics (with --opa-metrics) separately from the response
+ */
+func (tr TestRunner) PostWithMetrics() (string, map[string]int64, error) {
 
-	switch tr.Source.Input.InputType {
-	case "inline":
+	input, query, err := tr.expandedRequest()
+	if err != nil {
+		return "", nil, err
+	}
 
-		// read the JSON data directly from the .raygun file
-		preExpansionInput = optionally_add_input_key(tr.Source.Input.Value)
-	case "json-file":
+	mode := requestMode(tr.Source)
 
-		// read the JSON data from a file
-		log.Debug("Suite Directory: %s , filename: %s", tr.Source.Suite.Directory, tr.Source.Input.Value)
-		tmp, err := util.ReadFile(tr.Source.Suite.Directory, tr.Source.Input.Value)
-		if err != nil {
-			return "", err
-		}
+	response, err := tr.send(mode, input, query)
+	if err == nil {
+		tr.replayCoverage(mode, input, query)
+	}
 
-		preExpansionInput = optionally_add_input_key(tmp)
+	if err != nil || !config.OpaMetrics {
+		return response, nil, err
+	}
 
-	default:
-		return "", fmt.Errorf("unsupported input type: %s", tr.Source.Input.InputType)
+	response, metrics := extractMetrics(response)
+
+	return response, metrics, nil
+}
+
+/*
+ *  The test's input and query, with the ${} properties (and the generated JWT) expanded
+ */
+func (tr TestRunner) expandedRequest() (string, string, error) {
+
+	if tr.Shared == nil {
+		return tr.expandRequest()
+	}
+
+	tr.Shared.once.Do(func() {
+		tr.Shared.input, tr.Shared.query, tr.Shared.err = tr.expandRequest()
+	})
+
+	return tr.Shared.input, tr.Shared.query, tr.Shared.err
+}
+
+func (tr TestRunner) expandRequest() (string, string, error) {
+
+	parent := config.Resolver
+	if tr.Resolver != nil {
+		parent = tr.Resolver
 	}
 
+	// the generated JWT belongs to this test alone
+	resolver := parent.NewScope()
+
 	// we only process the JWT data if there's anything present to process, otherwise
 	// it's safe to ignore
 	if tr.Source.Jwt.Active || (len(tr.Source.Jwt.Claims.Custom) > 0) {
@@ -64,7 +144,7 @@ func (tr TestRunner) Post() (string, error) {
 		jwt_string, err := jwtBuilder.Generate(tr.Source.Suite, tr.Source.Jwt)
 
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 
 		log.Debug("Test: %s Generated JWT: %s", tr.Source.Name, jwt_string)
@@ -74,15 +154,67 @@ func (tr TestRunner) Post() (string, error) {
 		// so the user can just use ${RAYGUN_GENERATED_JWT} in their input
 		// document
 		if jwt_string != "" {
-			config.Resolver.AddProperty("RAYGUN_GENERATED_JWT", jwt_string)
+			resolver.AddProperty("RAYGUN_GENERATED_JWT", jwt_string)
 		}
 	}
 
+	// the input is wrapped (or not) once we know where it's going, in send()
+	preExpansionInput := ""
+
+	switch tr.Source.Input.InputType {
+	case "inline":
+
+		// read the JSON data directly from the .raygun file
+		preExpansionInput = tr.Source.Input.Value
+	case "json-file":
+
+		// read the JSON data from a file
+		log.Debug("Suite Directory: %s , filename: %s", tr.Source.Suite.Directory, tr.Source.Input.Value)
+		tmp, err := util.ReadFile(tr.Source.Suite.Directory, tr.Source.Input.Value)
+		if err != nil {
+			return "", "", err
+		}
+
+		preExpansionInput = tmp
+
+	case "http-request":
+
+		// build the Envoy CheckRequest from the compact request description. The query
+		// values are expanded first, since they're URL encoded into the path
+		request := tr.Source.Input.Request
+		request.Query = expandQuery(resolver, request.Query)
+
+		tmp, err := buildEnvoyInput(request)
+		if err != nil {
+			return "", "", err
+		}
+
+		preExpansionInput = tmp
+
+	case "admission-review":
+
+		// wrap the manifest in an AdmissionReview, like the API server would
+		tmp, err := buildAdmissionReview(tr.Source.Input.Admission)
+		if err != nil {
+			return "", "", err
+		}
+
+		preExpansionInput = tmp
+
+	case "":
+
+		// GET, query and the bare APIs can do without input, send() decides
+		preExpansionInput = ""
+
+	default:
+		return "", "", fmt.Errorf("unsupported input type: %s", tr.Source.Input.InputType)
+	}
+
 	// substitute any ${} tokens in the input with their appropriate values
 	// which are pulled either from properties or from the environment
-	bodyString := config.Resolver.ExpandProperties(preExpansionInput)
+	bodyString := resolver.ExpandProperties(preExpansionInput)
 
-	return _post(postUrl, bodyString)
+	return bodyString, resolver.ExpandProperties(tr.Source.Query), nil
 
 }
 
@@ -98,6 +230,12 @@ func (tr TestRunner) Evaluate(response string) (types.TestResult, error) {
 
 	log.Debug("Expectations: %v . Actual: %s", tr.Source.ExpectData, response)
 
+	result.Actual = response
+
+	if requestMode(tr.Source) == REQUEST_COMPILE {
+		result.Details = compileDetails(response)
+	}
+
 	for _, expected := range tr.Source.ExpectData {
 
 		if result.Status != config.FAIL {
@@ -105,7 +243,6 @@ func (tr TestRunner) Evaluate(response string) (types.TestResult, error) {
 			switch expected.ExpectationType {
 			case "substring":
 				compressed_actual := util.RemoveAllWhitespace(response)
-				result.Actual = response
 
 				expected := util.RemoveAllWhitespace(expected.Target)
 				if strings.Contains(compressed_actual, expected) {
@@ -114,6 +251,39 @@ func (tr TestRunner) Evaluate(response string) (types.TestResult, error) {
 					result.Status = config.FAIL
 				}
 
+			case "allowed", "http_status", "headers", "response_headers_to_add", "message", "patch":
+				decision, err := tr.decision(response)
+				if err != nil {
+					log.Debug("Unable to parse the response for %s as JSON: %s", tr.Source, err.Error())
+					result.Status = config.FAIL
+					continue
+				}
+
+				passed, err := evaluateDecisionExpectation(expected, decision)
+				if err != nil {
+					return result, err
+				}
+
+				if passed {
+					result.Status = config.PASS
+				} else {
+					result.Status = config.FAIL
+				}
+
+			case "residual", "references":
+				passed, err := evaluateCompileExpectation(expected.ExpectationType, expected.Target, response)
+				if err != nil {
+					log.Debug("Unable to parse the compile response for %s: %s", tr.Source, err.Error())
+					result.Status = config.FAIL
+					continue
+				}
+
+				if passed {
+					result.Status = config.PASS
+				} else {
+					result.Status = config.FAIL
+				}
+
 			default:
 				log.Fatal("Unsupported ExpectationType for %s -> %s", tr.Source, expected.ExpectationType)
 			}
@@ -148,7 +318,7 @@ func _post(url string, body string) (string, error) {
 
 	bodyBytes := []byte(body)
 
-	response, err := http.Post(url, "application/json", bytes.NewReader(bodyBytes))
+	response, err := httpClient.Post(url, "application/json", bytes.NewReader(bodyBytes))
 
 	if err != nil {
 		log.Error("Attempted to complete POST to %s with payload %s -> %s", url, body, err.Error())
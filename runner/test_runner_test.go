@@ -0,0 +1,156 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package runner
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"raygun/config"
+	"raygun/opa"
+	"raygun/types"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+ *  With --concurrency, every test generates its JWT into its own property scope,
+ *  so tests in flight at the same time never send each other's tokens
+ */
+func TestPost_ConcurrentJwts(t *testing.T) {
+
+	previous := config.Resolver
+	defer func() { config.Resolver = previous }()
+
+	config.Resolver = config.NewPropertyResolver()
+
+	// an OPA that answers with the input it was sent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer server.Close()
+
+	suite := types.TestSuite{Jwt: types.TestJwt{Algorithm: "HS256", Secret: "s3cret"}, Opa: opa.OpaConfig{EndpointUrl: server.URL}}
+
+	var wg sync.WaitGroup
+	errors := make(chan error, 50)
+
+	for i := 0; i < 50; i++ {
+
+		test := types.TestRecord{
+			Name:         fmt.Sprintf("test-%d", i),
+			Suite:        suite,
+			DecisionPath: "/v1/data/app/allow",
+			Input:        types.TestInput{InputType: "inline", Value: `{"token": "${RAYGUN_GENERATED_JWT}"}`},
+			Jwt:          types.TestJwt{Active: true, Claims: types.ClaimsConfig{Custom: map[string]interface{}{"test": fmt.Sprintf("test-%d", i)}}},
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			input, err := NewTestRunner(test).Post()
+			if err != nil {
+				errors <- err
+				return
+			}
+
+			var document struct{ Input struct{ Token string } }
+			json.Unmarshal([]byte(input), &document)
+
+			parts := strings.Split(document.Input.Token, ".")
+			if len(parts) != 3 {
+				errors <- fmt.Errorf("%s: expected a JWT, got %s", test.Name, input)
+				return
+			}
+
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			if !strings.Contains(string(payload), `"test":"`+test.Name+`"`) {
+				errors <- fmt.Errorf("%s: got another test's token: %s", test.Name, payload)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errors)
+
+	for err := range errors {
+		t.Error(err)
+	}
+
+	if expanded := config.Resolver.ExpandProperties("${RAYGUN_GENERATED_JWT}"); expanded != "${RAYGUN_GENERATED_JWT}" {
+		t.Errorf("the shared resolver shouldn't hold a generated JWT: %s", expanded)
+	}
+}
+
+/*
+ *  The decisions of one test are the same request sent to different places, so they
+ *  share one generated JWT, while another test gets its own
+ */
+func TestRunTests_DecisionsShareJwt(t *testing.T) {
+
+	previous := config.Resolver
+	defer func() { config.Resolver = previous }()
+
+	config.Resolver = config.NewPropertyResolver()
+
+	var lock sync.Mutex
+	tokens := make(map[string]string)
+
+	// an OPA that remembers the token each decision path was sent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var document struct{ Input struct{ Token string } }
+		json.NewDecoder(r.Body).Decode(&document)
+
+		lock.Lock()
+		tokens[r.URL.Path] = document.Input.Token
+		lock.Unlock()
+
+		w.Write([]byte(`{"result":true}`))
+	}))
+	defer server.Close()
+
+	suite := types.TestSuite{Jwt: types.TestJwt{Algorithm: "HS256", Secret: "s3cret"}, Opa: opa.OpaConfig{EndpointUrl: server.URL}}
+
+	test := func(name string, decision_path string, group_id int) types.TestRecord {
+		return types.TestRecord{
+			Name:         name,
+			Group:        "with decisions",
+			GroupId:      group_id,
+			DecisionPath: decision_path,
+			ExpectData:   []types.TestExpectation{{ExpectationType: "substring", Target: "true"}},
+			Input:        types.TestInput{InputType: "inline", Value: `{"token": "${RAYGUN_GENERATED_JWT}"}`},
+			Jwt:          types.TestJwt{Active: true, Claims: types.ClaimsConfig{Custom: map[string]interface{}{"decision": decision_path}}},
+		}
+	}
+
+	// every record has different claims, so the tokens only match if they were
+	// generated once (the timestamps alone are too coarse to tell)
+	suite.Tests = []types.TestRecord{
+		test("with decisions: allow", "/v1/data/app/allow", 1),
+		test("with decisions: reasons", "/v1/data/app/reasons", 1),
+		test("another", "/v1/data/app/other", 0),
+	}
+
+	_, test_errors := runTests(suite, 3, nil, nil)
+
+	for _, err := range test_errors {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if tokens["/v1/data/app/allow"] == "" || tokens["/v1/data/app/allow"] != tokens["/v1/data/app/reasons"] {
+		t.Errorf("expected the decisions to share a token, got %v", tokens)
+	}
+
+	if tokens["/v1/data/app/other"] == tokens["/v1/data/app/allow"] {
+		t.Errorf("expected another test to get a token of its own, got %v", tokens)
+	}
+}
@@ -15,12 +15,53 @@ import (
 )
 
 type TestSuite struct {
-	Opa         opa.OpaConfig `yaml:"opa"`
-	Name        string        `yaml:"name"`
-	Description string        `yaml:"description,omitempty"`
-	Directory   string        `yaml:"directory"`
-	Jwt         TestJwt       `yaml:"jwt,omitempty"`
-	Tests       []TestRecord  `yaml:"tests"`
+	Opa           opa.OpaConfig       `yaml:"opa"`
+	Name          string              `yaml:"name"`
+	Description   string              `yaml:"description,omitempty"`
+	Directory     string              `yaml:"directory"`
+	Jwt           TestJwt             `yaml:"jwt,omitempty"`
+	Reload        *ReloadConfig       `yaml:"reload,omitempty"`         // swap bundles partway through, see runner/reload.go
+	BundleExpects *BundleExpectations `yaml:"bundle-expects,omitempty"` // checks on the bundle itself
+	Data          []DataFixture       `yaml:"data,omitempty"`           // written to OPA before the tests, removed after
+	Tests         []TestRecord        `yaml:"tests"`
+}
+
+/*
+ *  A hot reload run: the suite's tests are sent over and over while the bundle
+ *  server swaps the suite's bundle for this one
+ */
+type ReloadConfig struct {
+	BundlePath string        `yaml:"bundle-path,omitempty"`
+	SourceDir  string        `yaml:"source-dir,omitempty"`
+	Roots      []string      `yaml:"roots,omitempty"`
+	Revision   string        `yaml:"revision,omitempty"`   // the revision that shows the new bundle is active
+	SwapAfter  time.Duration `yaml:"swap-after,omitempty"` // how long traffic runs before the swap
+	Settle     time.Duration `yaml:"settle,omitempty"`     // how long traffic runs after activation
+	Timeout    time.Duration `yaml:"timeout,omitempty"`    // how long we wait for activation
+}
+
+/*
+ *  What the suite's bundle should contain. Each check that's set becomes a result
+ *  of its own
+ */
+type BundleExpectations struct {
+	Source       string   `yaml:"source,omitempty"`      // file (the bundle-path) or live (what OPA has activated)
+	BundleName   string   `yaml:"bundle-name,omitempty"` // which of OPA's bundles to check, when it has several
+	Roots        []string `yaml:"roots,omitempty"`
+	Revision     string   `yaml:"revision,omitempty"`
+	MetadataKeys []string `yaml:"metadata-keys,omitempty"` // dotted paths, e.g. release.version
+	DataFiles    []string `yaml:"data-files,omitempty"`    // every data file in the bundle, no more, no less
+	Packages     []string `yaml:"packages,omitempty"`      // packages the bundle must include
+}
+
+/*
+ *  A document written into OPA with the Data API, and removed again afterwards
+ */
+type DataFixture struct {
+	Path   string      `yaml:"path"`             // where it goes, e.g. envoy/network/subnets
+	Value  interface{} `yaml:"value,omitempty"`  // inline YAML, or a JSON string
+	File   string      `yaml:"file,omitempty"`   // a JSON or YAML file, relative to the suite directory
+	Method string      `yaml:"method,omitempty"` // put (the default), or patch with a JSON Patch value
 }
 
 func (suite TestSuite) String() string {
@@ -35,9 +76,51 @@ type TestRecord struct {
 	Description  string            `yaml:"description,omitempty"`
 	ExpectsObj   interface{}       `yaml:"expects"`
 	Input        TestInput         `yaml:"input"`
-	DecisionPath string            `yaml:"decision-path"` // the path part of the URL to use to call opa
-	Jwt          TestJwt           `yaml:"jwt,omitempty"` // the structure containing the parts of the JWT
+	DecisionPath string            `yaml:"decision-path"`       // the path part of the URL to use to call opa
+	Method       string            `yaml:"method,omitempty"`    // POST (the default), or GET to read a data document
+	Query        string            `yaml:"query,omitempty"`     // an ad-hoc Rego query, sent to /v1/query
+	Unknowns     []string          `yaml:"unknowns,omitempty"`  // partially evaluate the query instead, through /v1/compile
+	Jwt          TestJwt           `yaml:"jwt,omitempty"`       // the structure containing the parts of the JWT
+	Data         []DataFixture     `yaml:"data,omitempty"`      // written to OPA before this test, removed after
+	Scenario     []ScenarioStep    `yaml:"scenario,omitempty"`  // ordered steps, run in place of a single query
+	Decisions    []TestDecision    `yaml:"decisions,omitempty"` // several decisions for one input, each becomes a test
+	Group        string            `yaml:"-"`                   // the test these decisions came from, for the report
+	GroupId      int               `yaml:"-"`                   // which test with decisions, counting from 1, since names needn't be unique
 	ExpectData   []TestExpectation // we parse ExpectsMap to create this
+
+	ExpectsAfterObj interface{}       `yaml:"expects-after,omitempty"` // reload suites: what the test expects once the new bundle is active
+	ExpectAfterData []TestExpectation `yaml:"-"`
+}
+
+/*
+ *  One of several decisions queried with the same input and JWT
+ */
+type TestDecision struct {
+	Name         string            `yaml:"name,omitempty"` // defaults to the decision path, without /v1/data/
+	DecisionPath string            `yaml:"decision-path"`
+	ExpectsObj   interface{}       `yaml:"expects"`
+	ExpectData   []TestExpectation `yaml:"-"`
+}
+
+/*
+ *  One step of a scenario test: Data API writes, and/or a policy query with its own
+ *  expectations. Values captured from the response are available to later steps
+ *  as ${name}
+ */
+type ScenarioStep struct {
+	Name         string            `yaml:"name"`
+	Data         []DataFixture     `yaml:"data,omitempty"`          // kept until the scenario ends
+	DecisionPath string            `yaml:"decision-path,omitempty"` // defaults to the test's decision-path
+	Input        TestInput         `yaml:"input,omitempty"`
+	ExpectsObj   interface{}       `yaml:"expects,omitempty"`
+	CaptureObj   interface{}       `yaml:"capture,omitempty"` // "name = $.result.id", a list of those, or a map of name to path
+	ExpectData   []TestExpectation `yaml:"-"`
+	Captures     []Capture         `yaml:"-"`
+}
+
+type Capture struct {
+	Name string
+	Path string // JSONPath into the response
 }
 
 func (tr TestRecord) String() string {
@@ -50,10 +133,11 @@ type CombinedResult struct {
 }
 
 type TestSuiteResult struct {
-	Source  TestSuite
-	Failed  []TestResult
-	Passed  []TestResult
-	Skipped []TestResult
+	Source   TestSuite
+	Failed   []TestResult
+	Passed   []TestResult
+	Skipped  []TestResult
+	Coverage *opa.CoverageReport // with --coverage
 }
 
 func (tsr TestSuiteResult) String() string {
@@ -64,12 +148,15 @@ func (tsr TestSuiteResult) String() string {
 }
 
 type TestResult struct {
-	Source   TestRecord
-	Actual   string
-	Status   string // fail, pass, skip
-	Start    time.Time
-	End      time.Time
-	Duration time.Duration
+	Source      TestRecord
+	Actual      string
+	Status      string // fail, pass, skip
+	Start       time.Time
+	End         time.Time
+	Duration    time.Duration
+	Details     string           // anything else worth reporting about how the result was reached
+	Explanation string           // OPA's trace of a failed decision, with --explain
+	Metrics     map[string]int64 // OPA's timers and counters for the request, with --opa-metrics
 }
 
 func (tr TestResult) String() string {
@@ -89,8 +176,10 @@ func (te TestExpectation) String() string {
 }
 
 type TestInput struct {
-	InputType string `yaml:"type"` // inline, filepath
-	Value     string `yaml:"value"`
+	InputType string           `yaml:"type"` // inline, json-file, http-request, admission-review
+	Value     string           `yaml:"value"`
+	Request   HttpRequest      `yaml:"request,omitempty"`   // for http-request inputs
+	Admission AdmissionRequest `yaml:"admission,omitempty"` // for admission-review inputs
 }
 
 func (ti TestInput) String() string {
@@ -99,9 +188,63 @@ func (ti TestInput) String() string {
 		return fmt.Sprintf("TestInput File: %s", ti.Value)
 	}
 
+	if ti.InputType == "http-request" {
+		return fmt.Sprintf("TestInput HTTP Request: %s %s", ti.Request.Method, ti.Request.Path)
+	}
+
+	if ti.InputType == "admission-review" {
+		return fmt.Sprintf("TestInput AdmissionReview: %s %s", ti.Admission.Operation, ti.Admission.Manifest)
+	}
+
+	if len(ti.Value) < 20 {
+		return fmt.Sprintf("TestInput: %s", ti.Value)
+	}
+
 	return fmt.Sprintf("TestInput: %s...", ti.Value[:20])
 }
 
+/*
+ *  A compact description of an HTTP request, which raygun expands into the
+ *  Envoy ext_authz CheckRequest that the OPA-Envoy plugin would send to OPA
+ */
+type HttpRequest struct {
+	Method    string                 `yaml:"method"`
+	Path      string                 `yaml:"path"` // may include a query string
+	Host      string                 `yaml:"host,omitempty"`
+	Scheme    string                 `yaml:"scheme,omitempty"`
+	Protocol  string                 `yaml:"protocol,omitempty"`
+	Headers   map[string]string      `yaml:"headers,omitempty"`
+	Query     map[string]interface{} `yaml:"query,omitempty"` // values are strings or lists of strings
+	Body      string                 `yaml:"body,omitempty"`
+	SourceIp  string                 `yaml:"source-ip,omitempty"`
+	Principal string                 `yaml:"principal,omitempty"`
+}
+
+/*
+ *  A Kubernetes manifest plus the details of the API request, which raygun wraps
+ *  into an admission.k8s.io/v1 AdmissionReview.
+ *
+ *  A manifest file can hold several documents. The parser splits those into
+ *  separate tests, and fills in Object/OldObject with one document each
+ */
+type AdmissionRequest struct {
+	Manifest    string        `yaml:"manifest"`               // file, relative to the suite directory
+	OldManifest string        `yaml:"old-manifest,omitempty"` // for UPDATE (and optionally DELETE)
+	Operation   string        `yaml:"operation"`              // CREATE, UPDATE, DELETE, CONNECT
+	Namespace   string        `yaml:"namespace,omitempty"`    // defaults to the object's namespace
+	User        AdmissionUser `yaml:"user,omitempty"`
+	DryRun      bool          `yaml:"dry-run,omitempty"`
+
+	Object    map[string]interface{} `yaml:"-"`
+	OldObject map[string]interface{} `yaml:"-"`
+}
+
+type AdmissionUser struct {
+	Username string   `yaml:"username"`
+	Uid      string   `yaml:"uid,omitempty"`
+	Groups   []string `yaml:"groups,omitempty"`
+}
+
 type TestJwt struct {
 	Algorithm  string       `yaml:"algorithm"`
 	Secret     string       `yaml:"secret,omitempty"`
@@ -0,0 +1,95 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+ *  A small subset of JSONPath, enough to pick a value out of an OPA response:
+ *
+ *    $.result.session_id
+ *    $.result.items[0].id
+ *    $['result']['odd key']
+ *
+ *  The document is what encoding/json decodes into an interface{}
+ */
+func JsonPath(document interface{}, path string) (interface{}, error) {
+
+	path = strings.TrimSpace(path)
+
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %s: it must start with $", path)
+	}
+
+	current := document
+	rest := path[1:]
+
+	for rest != "" {
+
+		var segment string
+		index := -1
+
+		switch {
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			segment = rest[:end]
+			rest = rest[end:]
+			if segment == "" {
+				return nil, fmt.Errorf("invalid JSONPath %s: empty key", path)
+			}
+
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSONPath %s: unterminated ['", path)
+			}
+			segment = rest[2:end]
+			rest = rest[end+2:]
+
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSONPath %s: unterminated [", path)
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(rest[1:end]))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %s: %s isn't an array index", path, rest[1:end])
+			}
+			index = n
+			rest = rest[end+1:]
+
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %s at %s", path, rest)
+		}
+
+		if index >= 0 {
+			array, ok := current.([]interface{})
+			if !ok || index >= len(array) {
+				return nil, fmt.Errorf("%s: no element %d", path, index)
+			}
+			current = array[index]
+			continue
+		}
+
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: no key %s", path, segment)
+		}
+
+		current, ok = object[segment]
+		if !ok {
+			return nil, fmt.Errorf("%s: no key %s", path, segment)
+		}
+	}
+
+	return current, nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright © 2025 PACLabs
+*/
+package util
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJsonPath(t *testing.T) {
+
+	var document interface{}
+
+	json.Unmarshal([]byte(`{"result": {"session_id": "abc", "items": [{"id": 1}, {"id": 2}], "odd key": true}}`), &document)
+
+	tests := map[string]interface{}{
+		"$.result.session_id":    "abc",
+		"$.result.items[1].id":   float64(2),
+		"$['result']['odd key']": true,
+		"$.result.items[0]":      map[string]interface{}{"id": float64(1)},
+	}
+
+	for path, expected := range tests {
+
+		value, err := JsonPath(document, path)
+		if err != nil {
+			t.Errorf("%s: %s", path, err.Error())
+			continue
+		}
+
+		got, _ := json.Marshal(value)
+		want, _ := json.Marshal(expected)
+
+		if string(got) != string(want) {
+			t.Errorf("%s: expected %s, got %s", path, string(want), string(got))
+		}
+	}
+
+	for _, path := range []string{"result.session_id", "$.result.missing", "$.result.items[5]", "$.result.items[x]", "$.result..id"} {
+		if _, err := JsonPath(document, path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}